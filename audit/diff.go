@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+)
+
+// redacted replaces values that shouldn't be revealed at all
+const redacted = "[redacted]"
+
+// trackedField describes how a user field is read and masked for the audit trail
+type trackedField struct {
+	name  string
+	value func(u *models.User) string
+	mask  func(value string) string
+}
+
+var trackedFields = []trackedField{
+	{"email", func(u *models.User) string { return u.Email }, maskEmail},
+	{"phone", func(u *models.User) string { return u.Phone }, maskKeepLast(2)},
+	{"date_of_birth", func(u *models.User) string { return u.DateOfBirth }, maskAll},
+	{"first_name", func(u *models.User) string { return u.FirstName }, maskKeepFirst},
+	{"last_name", func(u *models.User) string { return u.LastName }, maskKeepFirst},
+	{"credit_card_number", func(u *models.User) string { return formatInt(u.CreditCardNumber) }, maskKeepLast(4)},
+	{"expiration_date", func(u *models.User) string { return u.ExpirationDate }, maskAll},
+	{"cvc", func(u *models.User) string { return formatInt(u.CVC) }, maskAll},
+	{"deleted_at", func(u *models.User) string { return formatTime(u.DeletedAt) }, noMask},
+	{"purge_after", func(u *models.User) string { return formatTime(u.PurgeAfter) }, noMask},
+}
+
+// Diff returns the masked changes between two versions of a user, a nil before or after stands for
+// a user that didn't exist yet or doesn't exist anymore
+func Diff(before, after *models.User) []FieldChange {
+	if before == nil {
+		before = &models.User{}
+	}
+	if after == nil {
+		after = &models.User{}
+	}
+
+	var changes []FieldChange
+	for _, field := range trackedFields {
+		oldValue, newValue := field.value(before), field.value(after)
+		if oldValue == newValue {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field.name, Before: field.mask(oldValue), After: field.mask(newValue)})
+	}
+	return changes
+}
+
+func maskEmail(value string) string {
+	at := strings.LastIndex(value, "@")
+	if at < 1 {
+		return maskAll(value)
+	}
+	return firstChar(value) + "***" + value[at:]
+}
+
+func maskKeepLast(n int) func(string) string {
+	return func(value string) string {
+		// Count characters rather than bytes, so a multi-byte character is never split
+		chars := []rune(value)
+		if len(chars) <= n {
+			return maskAll(value)
+		}
+		return strings.Repeat("*", len(chars)-n) + string(chars[len(chars)-n:])
+	}
+}
+
+func maskKeepFirst(value string) string {
+	if value == "" {
+		return ""
+	}
+	return firstChar(value) + "***"
+}
+
+// firstChar returns the first character of value. Slicing off the first byte would split a multi-byte
+// character such as the É of Élodie, and the invalid UTF-8 left over can't be sent over gRPC.
+func firstChar(value string) string {
+	_, size := utf8.DecodeRuneInString(value)
+	return value[:size]
+}
+
+func maskAll(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
+
+func noMask(value string) string {
+	return value
+}
+
+func formatInt(value int32) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(value))
+}
+
+func formatTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339)
+}
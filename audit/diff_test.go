@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+)
+
+func TestDiffMasksByCharacter(t *testing.T) {
+	after := &models.User{
+		Email:     "élodie@example.com",
+		Phone:     "+31 6 1234 56７８",
+		FirstName: "Élodie",
+		LastName:  "Ørsted",
+	}
+	want := map[string]string{
+		"email":      "é***@example.com",
+		"phone":      "*************７８",
+		"first_name": "É***",
+		"last_name":  "Ø***",
+	}
+
+	changes := Diff(nil, after)
+	if len(changes) != len(want) {
+		t.Fatalf("Got %d change(s), want %d: %+v", len(changes), len(want), changes)
+	}
+	for _, change := range changes {
+		if !utf8.ValidString(change.After) {
+			t.Errorf("Masked %s %q isn't valid UTF-8", change.Field, change.After)
+		}
+		if change.After != want[change.Field] {
+			t.Errorf("Masked %s is %q, want %q", change.Field, change.After, want[change.Field])
+		}
+		if change.Before != "" {
+			t.Errorf("Masked %s was %q before, want it empty", change.Field, change.Before)
+		}
+	}
+}
+
+func TestDiffMasksASCII(t *testing.T) {
+	before := &models.User{Email: "jane@example.com", Phone: "0612345678", FirstName: "Jane", CVC: 123}
+	changes := Diff(before, nil)
+
+	want := map[string]string{
+		"email":      "j***@example.com",
+		"phone":      "********78",
+		"first_name": "J***",
+		"cvc":        redacted,
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Got %d change(s), want %d: %+v", len(changes), len(want), changes)
+	}
+	for _, change := range changes {
+		if change.Before != want[change.Field] || change.After != "" {
+			t.Errorf("Change of %s is %q to %q, want %q to empty", change.Field, change.Before, change.After, want[change.Field])
+		}
+	}
+}
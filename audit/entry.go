@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sources of a mutation
+const (
+	SourceGRPC   = "grpc"
+	SourceAMQP   = "amqp"
+	SourceSystem = "system"
+)
+
+// Entry is a single, append-only record of a change made to a user
+type Entry struct {
+	ID primitive.ObjectID `bson:"_id,omitempty"`
+	// UserID is the document id of the changed user, ExternalUserID the id used by the other services
+	UserID         string `bson:"userid,omitempty"`
+	ExternalUserID string `bson:"externaluserid,omitempty"`
+	// Actor is who made the change, Action the RPC or message action that caused it
+	Actor     string        `bson:"actor"`
+	Action    string        `bson:"action"`
+	Source    string        `bson:"source"`
+	Timestamp time.Time     `bson:"timestamp"`
+	Changes   []FieldChange `bson:"changes,omitempty"`
+}
+
+// FieldChange is the before and after value of a single field, personal data is masked
+type FieldChange struct {
+	Field  string `bson:"field"`
+	Before string `bson:"before,omitempty"`
+	After  string `bson:"after,omitempty"`
+}
+
+// Filter selects the entries of a user within a time range, zero times leave the range open
+type Filter struct {
+	UserID string
+	From   time.Time
+	To     time.Time
+}
+
+// NewEntry builds the entry for a change from before to after, either of which may be nil
+func NewEntry(actor, action, source string, before, after *models.User) *Entry {
+	entry := &Entry{
+		Actor:   actor,
+		Action:  action,
+		Source:  source,
+		Changes: Diff(before, after),
+	}
+	for _, user := range []*models.User{after, before} {
+		if user == nil {
+			continue
+		}
+		if entry.UserID == "" && !user.ID.IsZero() {
+			entry.UserID = user.ID.Hex()
+		}
+		if entry.ExternalUserID == "" {
+			entry.ExternalUserID = user.UserID
+		}
+	}
+	return entry
+}
+
+// Subject returns the id of the user the entry is about, preferring the document id
+func (e *Entry) Subject() string {
+	if e.UserID != "" {
+		return e.UserID
+	}
+	return e.ExternalUserID
+}
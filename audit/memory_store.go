@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps the audit trail in memory, it's meant for tests and local development
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// NewMemoryStore creates an empty in-memory audit store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Append(ctx context.Context, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	// Store a copy of the changes so later modifications by the caller don't alter history
+	stored := *entry
+	stored.Changes = append([]FieldChange(nil), entry.Changes...)
+	s.entries = append(s.entries, stored)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, filter Filter, fn func(*Entry) error) error {
+	s.mu.RLock()
+	var matches []Entry
+	for _, entry := range s.entries {
+		if filter.UserID != "" && entry.UserID != filter.UserID && entry.ExternalUserID != filter.UserID {
+			continue
+		}
+		if !filter.From.IsZero() && entry.Timestamp.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !entry.Timestamp.Before(filter.To) {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Timestamp.Before(matches[j].Timestamp) })
+	for i := range matches {
+		if err := fn(&matches[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
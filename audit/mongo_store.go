@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ Store = (*MongoStore)(nil)
+
+// MongoStore keeps the audit trail in a MongoDB collection
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoStore creates an audit store backed by the given collection
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+// EnsureIndexes creates the indexes used to look up the entries of a user by time
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "externaluserid", Value: 1}, {Key: "timestamp", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoStore) Append(ctx context.Context, entry *Entry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	_, err := s.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *MongoStore) List(ctx context.Context, filter Filter, fn func(*Entry) error) error {
+	query := bson.M{}
+	if filter.UserID != "" {
+		query["$or"] = bson.A{bson.M{"userid": filter.UserID}, bson.M{"externaluserid": filter.UserID}}
+	}
+	timeRange := bson.M{}
+	if !filter.From.IsZero() {
+		timeRange["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		timeRange["$lt"] = filter.To
+	}
+	if len(timeRange) > 0 {
+		query["timestamp"] = timeRange
+	}
+
+	cursor, err := s.collection.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		entry := &Entry{}
+		if err := cursor.Decode(entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,11 @@
+package audit
+
+import "context"
+
+// Store persists audit entries. It's append-only on purpose: entries can be added and read, never changed.
+type Store interface {
+	// Append adds an entry, filling in its ID and timestamp when they are empty
+	Append(ctx context.Context, entry *Entry) error
+	// List calls fn for every entry matching the filter in chronological order, stopping at the first error
+	List(ctx context.Context, filter Filter, fn func(*Entry) error) error
+}
@@ -1,16 +1,90 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 type Config struct {
-	Port              string `mapstructure:"PORT"`
-	MongoDBUser       string `mapstructure:"MONGODB_USER"`
-	MongoDBPwd        string `mapstructure:"MONGODB_PWD"`
-	MongoDBCluster    string `mapstructure:"MONGODB_CLUSTER"`
-	MongoDBDb         string `mapstructure:"MONGODB_DB"`
-	MongoDBCollection string `mapstructure:"MONGODB_COLLECTION"`
-	RabbitMQUser      string `mapstructure:"RABBITMQ_USER"`
-	RabbitMQPwd       string `mapstructure:"RABBITMQ_PWD"`
+	Port                      string `mapstructure:"PORT"`
+	MongoDBUser               string `mapstructure:"MONGODB_USER"`
+	MongoDBPwd                string `mapstructure:"MONGODB_PWD"`
+	MongoDBCluster            string `mapstructure:"MONGODB_CLUSTER"`
+	MongoDBDb                 string `mapstructure:"MONGODB_DB"`
+	MongoDBCollection         string `mapstructure:"MONGODB_COLLECTION"`
+	MongoDBAuditCollection    string `mapstructure:"MONGODB_AUDIT_COLLECTION"`
+	MongoDBOutboxCollection   string `mapstructure:"MONGODB_OUTBOX_COLLECTION"`
+	MongoDBExportCollection   string `mapstructure:"MONGODB_EXPORT_COLLECTION"`
+	MongoDBDeletionCollection string `mapstructure:"MONGODB_DELETION_COLLECTION"`
+	MigrateOnStartup          bool   `mapstructure:"MIGRATE_ON_STARTUP"`
+	// MongoDBProcessedCollection remembers the consumed messages for ProcessedMessageTTL, redeliveries are skipped
+	MongoDBProcessedCollection string        `mapstructure:"MONGODB_PROCESSED_COLLECTION"`
+	ProcessedMessageTTL        time.Duration `mapstructure:"PROCESSED_MESSAGE_TTL"`
+	// DeletionGracePeriod is how long a deleted user can be restored, PurgeInterval how often expired users are erased
+	DeletionGracePeriod time.Duration `mapstructure:"DELETION_GRACE_PERIOD"`
+	PurgeInterval       time.Duration `mapstructure:"PURGE_INTERVAL"`
+	// DeletionRetryInterval is how long a downstream service gets to confirm an erasure before it's asked again
+	DeletionRetryInterval time.Duration `mapstructure:"DELETION_RETRY_INTERVAL"`
+	// UserDataTimeout is how long GetAllUserData waits for the downstream services to reply
+	UserDataTimeout time.Duration `mapstructure:"USER_DATA_TIMEOUT"`
+	// ExportWorkerInterval is how often pending data exports are picked up, ExportRetention how long archives are kept
+	ExportWorkerInterval time.Duration `mapstructure:"EXPORT_WORKER_INTERVAL"`
+	ExportRetention      time.Duration `mapstructure:"EXPORT_RETENTION"`
+	// OutboxRelayInterval is how often the outbox is checked for messages to publish
+	OutboxRelayInterval time.Duration `mapstructure:"OUTBOX_RELAY_INTERVAL"`
+	RabbitMQUser        string        `mapstructure:"RABBITMQ_USER"`
+	RabbitMQPwd         string        `mapstructure:"RABBITMQ_PWD"`
+	// RabbitMQChannelPoolSize is the number of channels publishes share
+	RabbitMQChannelPoolSize int `mapstructure:"RABBITMQ_CHANNEL_POOL_SIZE"`
+	// RabbitMQMaxRequests is the number of requests, such as GetAllUserData, waiting for replies at the same time
+	RabbitMQMaxRequests int `mapstructure:"RABBITMQ_MAX_REQUESTS"`
+	// RabbitMQPublishAttempts is how often a publish the broker didn't confirm is attempted, with RabbitMQPublishRetryDelay in between
+	RabbitMQPublishAttempts   int           `mapstructure:"RABBITMQ_PUBLISH_ATTEMPTS"`
+	RabbitMQPublishRetryDelay time.Duration `mapstructure:"RABBITMQ_PUBLISH_RETRY_DELAY"`
+	// ConsumerMaxAttempts is how often a message is handled before it's dead-lettered, ConsumerRetryDelay the wait
+	// before the first retry, which doubles with every following retry
+	ConsumerMaxAttempts int           `mapstructure:"CONSUMER_MAX_ATTEMPTS"`
+	ConsumerRetryDelay  time.Duration `mapstructure:"CONSUMER_RETRY_DELAY"`
+	// ConsumerWorkers is the number of messages handled at once, ConsumerPrefetch the number of unacknowledged
+	// messages the broker hands out
+	ConsumerWorkers  int `mapstructure:"CONSUMER_WORKERS"`
+	ConsumerPrefetch int `mapstructure:"CONSUMER_PREFETCH"`
+	// ShutdownTimeout is how long running RPCs and messages get to finish when the service is stopped
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	// MetricsPort is where the counters are served on /debug/vars, leave it empty to not serve them
+	MetricsPort string `mapstructure:"METRICS_PORT"`
+	// RabbitMQScheme is amqp or amqps, RabbitMQCluster the host of the broker and RabbitMQPort its port, 0 meaning
+	// the default port of the scheme
+	RabbitMQScheme  string `mapstructure:"RABBITMQ_SCHEME"`
+	RabbitMQCluster string `mapstructure:"RABBITMQ_CLUSTER"`
+	RabbitMQPort    int    `mapstructure:"RABBITMQ_PORT"`
+	// RabbitMQVhost is the virtual host, when it's empty the vhost is named after RabbitMQUser like CloudAMQP does
+	RabbitMQVhost string `mapstructure:"RABBITMQ_VHOST"`
+	// RabbitMQCACert is the CA file the broker's certificate is verified against, the system's CAs are used when
+	// it's empty. RabbitMQClientCert and RabbitMQClientKey are the client certificate for brokers that require one.
+	RabbitMQCACert     string `mapstructure:"RABBITMQ_CA_CERT"`
+	RabbitMQClientCert string `mapstructure:"RABBITMQ_CLIENT_CERT"`
+	RabbitMQClientKey  string `mapstructure:"RABBITMQ_CLIENT_KEY"`
+	// RabbitMQHeartbeat is how often heartbeats are exchanged, a connection that misses them is considered lost
+	RabbitMQHeartbeat time.Duration `mapstructure:"RABBITMQ_HEARTBEAT"`
+	// The queues and exchange the service works with. Their durability and arguments, given as a JSON object
+	// such as {"x-queue-type": "quorum"}, have to match the queues and exchange on the broker.
+	RabbitMQUserQueue                 string `mapstructure:"RABBITMQ_USER_QUEUE"`
+	RabbitMQUserQueueDurable          bool   `mapstructure:"RABBITMQ_USER_QUEUE_DURABLE"`
+	RabbitMQUserQueueArgs             string `mapstructure:"RABBITMQ_USER_QUEUE_ARGS"`
+	RabbitMQDeletionReplyQueue        string `mapstructure:"RABBITMQ_DELETION_REPLY_QUEUE"`
+	RabbitMQDeletionReplyQueueDurable bool   `mapstructure:"RABBITMQ_DELETION_REPLY_QUEUE_DURABLE"`
+	RabbitMQDeletionReplyQueueArgs    string `mapstructure:"RABBITMQ_DELETION_REPLY_QUEUE_ARGS"`
+	RabbitMQEventsExchange            string `mapstructure:"RABBITMQ_EVENTS_EXCHANGE"`
+	RabbitMQEventsExchangeDurable     bool   `mapstructure:"RABBITMQ_EVENTS_EXCHANGE_DURABLE"`
+	RabbitMQEventsExchangeArgs        string `mapstructure:"RABBITMQ_EVENTS_EXCHANGE_ARGS"`
+	// The queues of the downstream services, which are asked for the data of users and to erase them
+	RabbitMQAuthQueue              string `mapstructure:"RABBITMQ_AUTH_QUEUE"`
+	RabbitMQAuthzQueue             string `mapstructure:"RABBITMQ_AUTHZ_QUEUE"`
+	RabbitMQWatchHistoryQueue      string `mapstructure:"RABBITMQ_WATCH_HISTORY_QUEUE"`
+	RabbitMQDownstreamQueueDurable bool   `mapstructure:"RABBITMQ_DOWNSTREAM_QUEUE_DURABLE"`
+	RabbitMQDownstreamQueueArgs    string `mapstructure:"RABBITMQ_DOWNSTREAM_QUEUE_ARGS"`
 }
 
 func LoadConfig() (config Config, err error) {
@@ -18,6 +92,46 @@ func LoadConfig() (config Config, err error) {
 	viper.SetConfigName("dev")
 	viper.SetConfigType("env")
 
+	// Defaults for settings that are optional in the env file
+	viper.SetDefault("MONGODB_AUDIT_COLLECTION", "user_audit")
+	viper.SetDefault("MONGODB_OUTBOX_COLLECTION", "user_outbox")
+	viper.SetDefault("MONGODB_EXPORT_COLLECTION", "user_exports")
+	viper.SetDefault("MONGODB_DELETION_COLLECTION", "user_deletions")
+	viper.SetDefault("MONGODB_PROCESSED_COLLECTION", "user_processed_messages")
+	viper.SetDefault("PROCESSED_MESSAGE_TTL", "168h")
+	viper.SetDefault("DELETION_GRACE_PERIOD", "720h")
+	viper.SetDefault("PURGE_INTERVAL", "1h")
+	viper.SetDefault("DELETION_RETRY_INTERVAL", "10m")
+	viper.SetDefault("OUTBOX_RELAY_INTERVAL", "1s")
+	viper.SetDefault("USER_DATA_TIMEOUT", "10s")
+	viper.SetDefault("RABBITMQ_CHANNEL_POOL_SIZE", 8)
+	viper.SetDefault("RABBITMQ_MAX_REQUESTS", 32)
+	viper.SetDefault("RABBITMQ_PUBLISH_ATTEMPTS", 3)
+	viper.SetDefault("RABBITMQ_PUBLISH_RETRY_DELAY", "200ms")
+	viper.SetDefault("CONSUMER_MAX_ATTEMPTS", 5)
+	viper.SetDefault("CONSUMER_RETRY_DELAY", "5s")
+	viper.SetDefault("CONSUMER_WORKERS", 4)
+	viper.SetDefault("CONSUMER_PREFETCH", 16)
+	viper.SetDefault("EXPORT_WORKER_INTERVAL", "5s")
+	viper.SetDefault("EXPORT_RETENTION", "168h")
+	viper.SetDefault("METRICS_PORT", "")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "30s")
+	viper.SetDefault("RABBITMQ_SCHEME", "amqp")
+	viper.SetDefault("RABBITMQ_CLUSTER", "localhost")
+	viper.SetDefault("RABBITMQ_PORT", 0)
+	viper.SetDefault("RABBITMQ_VHOST", "")
+	viper.SetDefault("RABBITMQ_HEARTBEAT", "10s")
+	viper.SetDefault("RABBITMQ_USER_QUEUE", "user_queue")
+	viper.SetDefault("RABBITMQ_USER_QUEUE_DURABLE", true)
+	viper.SetDefault("RABBITMQ_DELETION_REPLY_QUEUE", "user_deletion_replies")
+	viper.SetDefault("RABBITMQ_DELETION_REPLY_QUEUE_DURABLE", true)
+	viper.SetDefault("RABBITMQ_EVENTS_EXCHANGE", "user_events")
+	viper.SetDefault("RABBITMQ_EVENTS_EXCHANGE_DURABLE", true)
+	viper.SetDefault("RABBITMQ_AUTH_QUEUE", "auth_queue")
+	viper.SetDefault("RABBITMQ_AUTHZ_QUEUE", "authz_queue")
+	viper.SetDefault("RABBITMQ_WATCH_HISTORY_QUEUE", "watch_history_queue")
+	viper.SetDefault("RABBITMQ_DOWNSTREAM_QUEUE_DURABLE", false)
+
 	viper.AutomaticEnv()
 
 	err = viper.ReadInConfig()
@@ -0,0 +1,230 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+)
+
+const (
+	// sagaCheckInterval is how often the coordinator looks for steps that are due for another attempt
+	sagaCheckInterval = time.Minute
+	sagaBatchSize     = 100
+	// retryMaxDelay caps the exponential backoff between attempts, services are asked at least once a day
+	retryMaxDelay = 24 * time.Hour
+	// maxResponseLength limits how much of a confirmation is kept as evidence
+	maxResponseLength = 4096
+)
+
+// coordinatorActor is recorded in the audit trail when an erasure completes
+const coordinatorActor = "deletion_saga"
+
+// Coordinator drives the deletion sagas: it records the confirmations of the downstream services and asks
+// the services that didn't confirm again, until all of them did
+type Coordinator struct {
+	sagas  SagaStore
+	audit  audit.Store
+	outbox outbox.Store
+	tx     repository.Transactor
+	// retryInterval is how long a service gets to confirm before it's asked again, it doubles with every attempt
+	retryInterval time.Duration
+	routing       Routing
+}
+
+// NewCoordinator creates a coordinator that asks services again when they didn't confirm within retryInterval
+func NewCoordinator(sagas SagaStore, auditStore audit.Store, outboxStore outbox.Store, tx repository.Transactor, retryInterval time.Duration, routing Routing) *Coordinator {
+	return &Coordinator{sagas: sagas, audit: auditStore, outbox: outboxStore, tx: tx, retryInterval: retryInterval, routing: routing}
+}
+
+// Run retries unconfirmed steps until the context is cancelled
+func (c *Coordinator) Run(ctx context.Context) {
+	ticker := time.NewTicker(sagaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.RetryDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Retrying deletion steps failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// start creates the saga of the user and queues the requests to all services, it has to be called within the
+// transaction that purges the user
+func (c *Coordinator) start(ctx context.Context, userID string, deletedAt time.Time) (*Saga, error) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	saga := newSaga(userID, c.routing.Participants, deletedAt, now, now.Add(c.retryDelay(1)))
+	if err := c.sagas.Create(ctx, saga); err != nil {
+		return nil, err
+	}
+	for i := range saga.Steps {
+		message, err := deleteAllRecordsMessage(saga, &saga.Steps[i], c.routing.ReplyQueue)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.outbox.Add(ctx, message); err != nil {
+			return nil, err
+		}
+	}
+	return saga, nil
+}
+
+// RetryDue asks the services that haven't confirmed in time to erase the user again. Erasing is idempotent,
+// so a service that did erase the data but whose confirmation got lost simply confirms again.
+func (c *Coordinator) RetryDue(ctx context.Context) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	sagas, err := c.sagas.Due(ctx, now, sagaBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, saga := range sagas {
+		for _, step := range saga.Steps {
+			if step.Status == StepConfirmed || step.NextAttemptAt.After(now) {
+				continue
+			}
+			if err := c.retry(ctx, saga, step, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retry queues another request to the service of the step along with the updated step
+func (c *Coordinator) retry(ctx context.Context, saga *Saga, step Step, now time.Time) error {
+	message, err := deleteAllRecordsMessage(saga, &step, c.routing.ReplyQueue)
+	if err != nil {
+		return err
+	}
+	step.Status = StepPending
+	step.Attempts++
+	step.LastAttemptAt = now
+	step.NextAttemptAt = now.Add(c.retryDelay(step.Attempts))
+
+	err = c.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := c.sagas.UpdateStep(ctx, saga.ID, step); err != nil {
+			return err
+		}
+		return c.outbox.Add(ctx, message)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Asked %s again to erase user %s (attempt %d)", step.Service, saga.UserID, step.Attempts)
+	return nil
+}
+
+// HandleReply records the reply of a service to a deletion request. A reply with a failure leaves the step
+// to be retried, a confirmation completes the saga when it was the last one missing.
+func (c *Coordinator) HandleReply(correlationID string, body []byte, failure string) error {
+	ctx := context.Background()
+
+	sagaID, service, err := parseCorrelationID(correlationID)
+	if err != nil {
+		// Nothing to retry for a reply that can't be matched to a saga
+		log.Printf("Ignoring deletion reply with correlation id %q: %v", correlationID, err)
+		return nil
+	}
+	saga, err := c.sagas.Get(ctx, sagaID)
+	if errors.Is(err, ErrSagaNotFound) {
+		log.Printf("Ignoring deletion reply for unknown saga %s", sagaID.Hex())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	step := saga.Step(service)
+	if step == nil {
+		log.Printf("Ignoring deletion reply from %s, it isn't part of saga %s", service, sagaID.Hex())
+		return nil
+	}
+	if step.Status == StepConfirmed {
+		// Retries can lead to more than one confirmation
+		return nil
+	}
+
+	if failure != "" {
+		step.Status = StepFailed
+		step.Error = failure
+		log.Printf("%s failed to erase user %s: %s", service, saga.UserID, failure)
+		return c.sagas.UpdateStep(ctx, saga.ID, *step)
+	}
+
+	step.Status = StepConfirmed
+	step.ConfirmedAt = time.Now().UTC().Truncate(time.Millisecond)
+	step.Response = truncate(string(body), maxResponseLength)
+	step.Error = ""
+	if err := c.sagas.UpdateStep(ctx, saga.ID, *step); err != nil {
+		return err
+	}
+	log.Printf("%s confirmed the erasure of user %s", service, saga.UserID)
+
+	// Other confirmations may have arrived in the meantime, so check the stored saga
+	if saga, err = c.sagas.Get(ctx, sagaID); err != nil {
+		return err
+	}
+	if !saga.Confirmed() {
+		return nil
+	}
+	return c.complete(ctx, saga)
+}
+
+// complete marks the erasure complete along with its audit entry and user.erased event
+func (c *Coordinator) complete(ctx context.Context, saga *Saga) error {
+	var completed bool
+	err := c.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if completed, err = c.sagas.Complete(ctx, saga.ID, time.Now().UTC().Truncate(time.Millisecond)); err != nil || !completed {
+			return err
+		}
+		subject := &models.User{UserID: saga.UserID}
+		if err := c.audit.Append(ctx, audit.NewEntry(coordinatorActor, "CompleteErasure", audit.SourceSystem, subject, nil)); err != nil {
+			return err
+		}
+		event, err := events.NewUserEventMessage(c.routing.EventsExchange, events.TypeErased, subject, nil)
+		if err != nil {
+			return err
+		}
+		return c.outbox.Add(ctx, event)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete deletion saga %s: %v", saga.ID.Hex(), err)
+	}
+	if completed {
+		log.Printf("All services confirmed the erasure of user %s", saga.UserID)
+	}
+	return nil
+}
+
+// retryDelay is how long to wait for a confirmation after the given attempt
+func (c *Coordinator) retryDelay(attempts int) time.Duration {
+	delay := float64(c.retryInterval) * math.Pow(2, float64(attempts-1))
+	if delay > float64(retryMaxDelay) {
+		return retryMaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,122 @@
+package deletion
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ SagaStore = (*MemorySagaStore)(nil)
+
+// MemorySagaStore keeps the sagas in memory, it's meant for tests and local development
+type MemorySagaStore struct {
+	mu    sync.Mutex
+	sagas []*Saga
+}
+
+// NewMemorySagaStore creates an empty in-memory saga store
+func NewMemorySagaStore() *MemorySagaStore {
+	return &MemorySagaStore{}
+}
+
+func (s *MemorySagaStore) Create(ctx context.Context, saga *Saga) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saga.ID.IsZero() {
+		saga.ID = primitive.NewObjectID()
+	}
+	s.sagas = append(s.sagas, copySaga(saga))
+	return nil
+}
+
+func (s *MemorySagaStore) Get(ctx context.Context, id primitive.ObjectID) (*Saga, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saga := range s.sagas {
+		if saga.ID == id {
+			return copySaga(saga), nil
+		}
+	}
+	return nil, ErrSagaNotFound
+}
+
+func (s *MemorySagaStore) Latest(ctx context.Context, userID string) (*Saga, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Sagas are appended in order, so the last match is the most recent
+	for i := len(s.sagas) - 1; i >= 0; i-- {
+		if s.sagas[i].UserID == userID {
+			return copySaga(s.sagas[i]), nil
+		}
+	}
+	return nil, ErrSagaNotFound
+}
+
+func (s *MemorySagaStore) Due(ctx context.Context, now time.Time, limit int) ([]*Saga, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Saga
+	for _, saga := range s.sagas {
+		if saga.Status != SagaInProgress || !hasDueStep(saga, now) {
+			continue
+		}
+		due = append(due, copySaga(saga))
+		if limit > 0 && len(due) == limit {
+			break
+		}
+	}
+	return due, nil
+}
+
+func (s *MemorySagaStore) UpdateStep(ctx context.Context, id primitive.ObjectID, step Step) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saga := range s.sagas {
+		if saga.ID != id {
+			continue
+		}
+		if stored := saga.Step(step.Service); stored != nil && stored.Status != StepConfirmed {
+			*stored = step
+		}
+		return nil
+	}
+	return nil
+}
+
+func (s *MemorySagaStore) Complete(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saga := range s.sagas {
+		if saga.ID == id && saga.Status == SagaInProgress {
+			saga.Status = SagaCompleted
+			saga.CompletedAt = at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasDueStep is the in-memory counterpart of the $elemMatch filter used for MongoDB
+func hasDueStep(saga *Saga, now time.Time) bool {
+	for _, step := range saga.Steps {
+		if step.Status != StepConfirmed && !step.NextAttemptAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// copySaga copies the saga including its steps, so callers can't modify the stored saga
+func copySaga(saga *Saga) *Saga {
+	copied := *saga
+	copied.Steps = append([]Step(nil), saga.Steps...)
+	return &copied
+}
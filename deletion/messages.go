@@ -0,0 +1,27 @@
+package deletion
+
+import (
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+)
+
+// deleteAllRecordsMessage builds the outbox message telling the service of the step to erase the user's data.
+// The service confirms by replying to replyQueue with the same correlation id.
+func deleteAllRecordsMessage(saga *Saga, step *Step, replyQueue string) (*outbox.Message, error) {
+	envelope, err := events.NewEnvelope("deleteAllRecords", map[string]interface{}{
+		"user_id": saga.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	message, err := events.NewOutboxMessage(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Key = saga.UserID
+	message.RoutingKey = step.Queue
+	message.ReplyTo = replyQueue
+	message.CorrelationID = correlationID(saga.ID, step.Service)
+	return message, nil
+}
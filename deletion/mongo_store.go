@@ -0,0 +1,102 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ SagaStore = (*MongoSagaStore)(nil)
+
+// MongoSagaStore keeps the sagas in a MongoDB collection. Sagas are never removed, they're the evidence
+// that a user was erased.
+type MongoSagaStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoSagaStore creates a store backed by the given collection
+func NewMongoSagaStore(collection *mongo.Collection) *MongoSagaStore {
+	return &MongoSagaStore{collection: collection}
+}
+
+// EnsureIndexes creates the indexes used to look up the sagas of a user and the steps that are due
+func (s *MongoSagaStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "purgedat", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "steps.nextattemptat", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoSagaStore) Create(ctx context.Context, saga *Saga) error {
+	if saga.ID.IsZero() {
+		saga.ID = primitive.NewObjectID()
+	}
+	_, err := s.collection.InsertOne(ctx, saga)
+	return err
+}
+
+func (s *MongoSagaStore) Get(ctx context.Context, id primitive.ObjectID) (*Saga, error) {
+	return decodeSaga(s.collection.FindOne(ctx, bson.M{"_id": id}))
+}
+
+func (s *MongoSagaStore) Latest(ctx context.Context, userID string) (*Saga, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "purgedat", Value: -1}, {Key: "_id", Value: -1}})
+	return decodeSaga(s.collection.FindOne(ctx, bson.M{"userid": userID}, opts))
+}
+
+func (s *MongoSagaStore) Due(ctx context.Context, now time.Time, limit int) ([]*Saga, error) {
+	filter := bson.M{
+		"status": SagaInProgress,
+		"steps": bson.M{"$elemMatch": bson.M{
+			"status":        bson.M{"$ne": StepConfirmed},
+			"nextattemptat": bson.M{"$lte": now},
+		}},
+	}
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "purgedat", Value: 1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var sagas []*Saga
+	if err := cursor.All(ctx, &sagas); err != nil {
+		return nil, err
+	}
+	return sagas, nil
+}
+
+func (s *MongoSagaStore) UpdateStep(ctx context.Context, id primitive.ObjectID, step Step) error {
+	// Replies and retries can race, a confirmation is final and never overwritten
+	filter := bson.M{
+		"_id":   id,
+		"steps": bson.M{"$elemMatch": bson.M{"service": step.Service, "status": bson.M{"$ne": StepConfirmed}}},
+	}
+	_, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"steps.$": step}})
+	return err
+}
+
+func (s *MongoSagaStore) Complete(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	filter := bson.M{"_id": id, "status": SagaInProgress}
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": SagaCompleted, "completedat": at}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// decodeSaga decodes a single result and translates a missing document into ErrSagaNotFound
+func decodeSaga(result *mongo.SingleResult) (*Saga, error) {
+	saga := &Saga{}
+	if err := result.Decode(saga); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSagaNotFound
+		}
+		return nil, err
+	}
+	return saga, nil
+}
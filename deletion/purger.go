@@ -0,0 +1,118 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/export"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+)
+
+// purgeBatchSize is the number of users erased per round
+const purgeBatchSize = 100
+
+// Purger erases users whose deletion grace period has passed
+type Purger struct {
+	users  repository.UserRepository
+	audit  audit.Store
+	outbox outbox.Store
+	// exports are the data exports of users, their archives are erased along with the user
+	exports export.Store
+	tx      repository.Transactor
+	// sagas starts the erasure of the user by the downstream services
+	sagas    *Coordinator
+	interval time.Duration
+}
+
+// purgerActor is recorded in the audit trail for users erased by the purger
+const purgerActor = "purger"
+
+// NewPurger creates a purger that checks for expired users every interval
+func NewPurger(users repository.UserRepository, auditStore audit.Store, outboxStore outbox.Store, exports export.Store, tx repository.Transactor, sagas *Coordinator, interval time.Duration) *Purger {
+	return &Purger{users: users, audit: auditStore, outbox: outboxStore, exports: exports, tx: tx, sagas: sagas, interval: interval}
+}
+
+// Run purges expired users until the context is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.PurgeExpired(ctx); err != nil {
+			log.Printf("Purging deleted users failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired erases every user whose grace period has passed
+func (p *Purger) PurgeExpired(ctx context.Context) error {
+	for {
+		userIDs, err := p.users.ListPurgeable(ctx, time.Now().UTC(), purgeBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+
+		for _, userID := range userIDs {
+			if err := p.purge(ctx, userID, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// purge erases the user and their data exports and starts the saga telling the downstream services to do the
+// same in one transaction, the outbox relay publishes its messages once the erasure is committed. Only the
+// documents whose grace period passed at now are erased, not a user created again with the same id since.
+func (p *Purger) purge(ctx context.Context, userID string, now time.Time) error {
+	var deleted, exports int64
+	err := p.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := p.users.FindByUserID(ctx, userID, repository.OnlyDeleted)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		if deleted, err = p.users.PurgeByUserID(ctx, userID, now); err != nil {
+			return err
+		}
+		if exports, err = p.exports.DeleteByUser(ctx, userID); err != nil {
+			return err
+		}
+		var deletedAt time.Time
+		if before != nil {
+			deletedAt = before.DeletedAt
+		}
+		if _, err := p.sagas.start(ctx, userID, deletedAt); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		if err := p.audit.Append(ctx, audit.NewEntry(purgerActor, "PurgeUser", audit.SourceSystem, before, nil)); err != nil {
+			return err
+		}
+		event, err := events.NewUserEventMessage(p.sagas.routing.EventsExchange, events.TypePurged, before, nil)
+		if err != nil {
+			return err
+		}
+		return p.outbox.Add(ctx, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Purged %d document(s) and %d data export(s) of deleted user %s", deleted, exports, userID)
+	return nil
+}
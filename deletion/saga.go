@@ -0,0 +1,131 @@
+package deletion
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Saga statuses
+const (
+	SagaInProgress = "in_progress"
+	SagaCompleted  = "completed"
+)
+
+// Step statuses
+const (
+	// StepPending means the service was asked to erase the data and hasn't replied yet
+	StepPending = "pending"
+	// StepFailed means the service replied that it couldn't erase the data, it's asked again later
+	StepFailed    = "failed"
+	StepConfirmed = "confirmed"
+)
+
+// ErrSagaNotFound is returned when no saga matches the given id or user
+var ErrSagaNotFound = errors.New("deletion saga not found")
+
+// Participant is a downstream service that keeps data about users, it's asked to erase them through its queue
+type Participant struct {
+	Service string
+	Queue   string
+}
+
+// Routing tells the coordinator where its messages go
+type Routing struct {
+	// Participants have to confirm the erasure of a user before the saga is complete
+	Participants []Participant
+	// ReplyQueue is where the participants confirm that they erased the data of a user
+	ReplyQueue string
+	// EventsExchange is where user.purged and user.erased are published
+	EventsExchange string
+}
+
+// Saga tracks the erasure of a user across the downstream services. It's started when the user is purged
+// and completes once every service confirmed, the record serves as evidence of the erasure.
+type Saga struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	UserID string             `bson:"userid"`
+	Status string             `bson:"status"`
+	// DeletedAt is when the deletion was requested, PurgedAt when this service erased the user and started the saga
+	DeletedAt   time.Time `bson:"deletedat,omitempty"`
+	PurgedAt    time.Time `bson:"purgedat"`
+	CompletedAt time.Time `bson:"completedat,omitempty"`
+	Steps       []Step    `bson:"steps"`
+}
+
+// Step is the erasure of the user by a single service
+type Step struct {
+	Service string `bson:"service"`
+	Queue   string `bson:"queue"`
+	Status  string `bson:"status"`
+	// Attempts counts how often the service was asked, the next attempt isn't made before NextAttemptAt
+	Attempts      int       `bson:"attempts"`
+	LastAttemptAt time.Time `bson:"lastattemptat"`
+	NextAttemptAt time.Time `bson:"nextattemptat"`
+	ConfirmedAt   time.Time `bson:"confirmedat,omitempty"`
+	// Response is the body of the confirmation, Error the reason the service gave for failing
+	Response string `bson:"response,omitempty"`
+	Error    string `bson:"error,omitempty"`
+}
+
+// newSaga creates a saga for the user with a step per participant, all of them asked once at purgedAt
+func newSaga(userID string, participants []Participant, deletedAt, purgedAt, nextAttemptAt time.Time) *Saga {
+	saga := &Saga{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Status:    SagaInProgress,
+		DeletedAt: deletedAt,
+		PurgedAt:  purgedAt,
+	}
+	for _, p := range participants {
+		saga.Steps = append(saga.Steps, Step{
+			Service:       p.Service,
+			Queue:         p.Queue,
+			Status:        StepPending,
+			Attempts:      1,
+			LastAttemptAt: purgedAt,
+			NextAttemptAt: nextAttemptAt,
+		})
+	}
+	return saga
+}
+
+// Confirmed reports whether every service confirmed the erasure
+func (s *Saga) Confirmed() bool {
+	for _, step := range s.Steps {
+		if step.Status != StepConfirmed {
+			return false
+		}
+	}
+	return true
+}
+
+// Step returns the step of the given service, or nil when the service isn't part of the saga
+func (s *Saga) Step(service string) *Step {
+	for i := range s.Steps {
+		if s.Steps[i].Service == service {
+			return &s.Steps[i]
+		}
+	}
+	return nil
+}
+
+// correlationID identifies the request to a service within a saga, replies carry it back
+func correlationID(sagaID primitive.ObjectID, service string) string {
+	return sagaID.Hex() + "." + service
+}
+
+// parseCorrelationID splits a correlation id into the saga id and the service
+func parseCorrelationID(id string) (primitive.ObjectID, string, error) {
+	sagaID, service, ok := strings.Cut(id, ".")
+	if !ok {
+		return primitive.NilObjectID, "", errors.New("malformed correlation id")
+	}
+	oid, err := primitive.ObjectIDFromHex(sagaID)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	return oid, service, nil
+}
@@ -0,0 +1,25 @@
+package deletion
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SagaStore keeps the deletion sagas
+type SagaStore interface {
+	// Create stores a new saga, use the context of a transaction to start it along with the purge
+	Create(ctx context.Context, saga *Saga) error
+	// Get returns the saga with the given id
+	Get(ctx context.Context, id primitive.ObjectID) (*Saga, error)
+	// Latest returns the most recent saga of the user
+	Latest(ctx context.Context, userID string) (*Saga, error)
+	// Due returns up to limit sagas in progress that have a step which isn't confirmed and is due for another attempt at now
+	Due(ctx context.Context, now time.Time, limit int) ([]*Saga, error)
+	// UpdateStep replaces the step of the same service, unless that step has already been confirmed
+	UpdateStep(ctx context.Context, id primitive.ObjectID, step Step) error
+	// Complete marks the saga completed at the given time. It reports whether the saga was still in progress,
+	// so the completion is recorded once.
+	Complete(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/config"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+)
+
+// runDLQCommand implements `dlq [list|replay|purge]`, it inspects the dead-letter queue of a consumed queue and
+// exits when done. Messages that aren't replayed or purged are put back on the dead-letter queue.
+func runDLQCommand(c config.Config, args []string) {
+	fs := flag.NewFlagSet("dlq", flag.ExitOnError)
+	queue := fs.String("queue", c.RabbitMQUserQueue, "queue whose dead letters to inspect")
+	limit := fs.Int("limit", 100, "maximum number of messages to fetch (0 = all)")
+	ids := fs.String("ids", "", "comma separated message ids to replay or purge")
+	positions := fs.String("positions", "", "comma separated positions, as shown by list, to replay or purge")
+	all := fs.Bool("all", false, "replay or purge every fetched message")
+	showBody := fs.Bool("body", false, "print the message bodies when listing")
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: app dlq [list|replay|purge] [flags]")
+		fs.PrintDefaults()
+	}
+
+	action := "list"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	if action != "list" && action != "replay" && action != "purge" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	selected, err := selector(*all, *ids, *positions)
+	if err != nil {
+		log.Fatalf("Invalid selection: %v", err)
+	}
+	if action != "list" && selected == nil {
+		log.Fatalf("Select the messages to %s with -ids, -positions or -all", action)
+	}
+
+	request := dlqRequest{action: action, queue: *queue, limit: *limit, selected: selected, showBody: *showBody, dryRun: *dryRun}
+	if err := handleDeadLetters(c, request); err != nil {
+		// Returning first runs the deferred closes, the channel hands unsettled messages back to RabbitMQ
+		log.Printf("dlq %s: %v", action, err)
+		os.Exit(1)
+	}
+}
+
+// dlqRequest is what the dlq command was asked to do
+type dlqRequest struct {
+	action   string
+	queue    string
+	limit    int
+	selected func(*messaging.DeadLetter) bool
+	showBody bool
+	dryRun   bool
+}
+
+// handleDeadLetters fetches the dead letters and lists, replays or purges them. It stops at the first message that
+// can't be put back on the dead-letter queue, and then prints what happened to the messages handled before.
+func handleDeadLetters(c config.Config, req dlqRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	amqpConfig, err := rabbitMQConfig(c)
+	if err != nil {
+		return fmt.Errorf("invalid RabbitMQ settings: %v", err)
+	}
+	topology, err := rabbitMQTopology(c)
+	if err != nil {
+		return fmt.Errorf("invalid RabbitMQ topology: %v", err)
+	}
+	rabbitMQ := messaging.NewConnectionManager(rabbitMQUrl(c), amqpConfig)
+	rabbitMQ.Start()
+	defer rabbitMQ.Close()
+	if _, err := rabbitMQ.WaitForConnection(ctx); err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+	publisher := messaging.NewPublisher(rabbitMQ, messaging.PublisherOptions{
+		PoolSize:    1,
+		MaxAttempts: c.RabbitMQPublishAttempts,
+		RetryDelay:  c.RabbitMQPublishRetryDelay,
+	})
+	defer publisher.Close(context.Background())
+
+	// The fetched messages stay unacknowledged on this channel until they're settled below. Closing it hands the
+	// messages that weren't settled back to the dead-letter queue.
+	ch, err := rabbitMQ.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+
+	letters, err := messaging.FetchDeadLetters(ch, req.queue, req.limit)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", messaging.DeadLetterQueue(req.queue), err)
+	}
+	if len(letters) == 0 {
+		fmt.Printf("%s is empty\n", messaging.DeadLetterQueue(req.queue))
+		return nil
+	}
+
+	// settled tells what happened to every message so far, it's printed when the command has to stop early
+	var settled []string
+	failures := 0
+	for i, letter := range letters {
+		outcome, err := handleDeadLetter(ctx, req, publisher, topology, letter)
+		if err != nil {
+			fmt.Printf("Stopped at message %d, %d message(s) weren't handled and stay on %s\n", letter.Position, len(letters)-i, messaging.DeadLetterQueue(req.queue))
+			if len(settled) > 0 {
+				fmt.Printf("Handled before stopping: %s\n", strings.Join(settled, ", "))
+			}
+			return err
+		}
+		if outcome == "failed" {
+			failures++
+		}
+		settled = append(settled, fmt.Sprintf("%d %s", letter.Position, outcome))
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d message(s) couldn't be %s and were kept", failures, pastTense(req.action))
+	}
+	return nil
+}
+
+// handleDeadLetter lists, replays or purges a single message and returns what happened to it. A message that
+// isn't replayed or purged is put back on the dead-letter queue, the error tells when that failed.
+func handleDeadLetter(ctx context.Context, req dlqRequest, publisher *messaging.Publisher, topology messaging.Topology, letter *messaging.DeadLetter) (string, error) {
+	switch {
+	case req.action == "list":
+		printDeadLetter(letter, req.showBody)
+		return "kept", keepDeadLetter(letter)
+	case !req.selected(letter):
+		return "kept", keepDeadLetter(letter)
+	case req.dryRun:
+		fmt.Printf("%4d  %-36s would be %s\n", letter.Position, displayID(letter), pastTense(req.action))
+		return "kept", keepDeadLetter(letter)
+	}
+
+	var err error
+	if req.action == "replay" {
+		err = publisher.Replay(ctx, letter, topology)
+	} else {
+		err = letter.Discard()
+	}
+	if err != nil {
+		fmt.Printf("%4d  %-36s failed: %v\n", letter.Position, displayID(letter), err)
+		return "failed", keepDeadLetter(letter)
+	}
+	fmt.Printf("%4d  %-36s %s\n", letter.Position, displayID(letter), pastTense(req.action))
+	return pastTense(req.action), nil
+}
+
+// selector returns the function picking the messages to replay or purge, nil when nothing was selected
+func selector(all bool, ids string, positions string) (func(*messaging.DeadLetter) bool, error) {
+	if all {
+		return func(*messaging.DeadLetter) bool { return true }, nil
+	}
+	if ids == "" && positions == "" {
+		return nil, nil
+	}
+
+	wantedIDs := map[string]bool{}
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			wantedIDs[id] = true
+		}
+	}
+	wantedPositions := map[int]bool{}
+	for _, position := range strings.Split(positions, ",") {
+		if position = strings.TrimSpace(position); position == "" {
+			continue
+		}
+		n, err := strconv.Atoi(position)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q is not a position", position)
+		}
+		wantedPositions[n] = true
+	}
+	return func(letter *messaging.DeadLetter) bool {
+		return wantedPositions[letter.Position] || (letter.MessageID != "" && wantedIDs[letter.MessageID])
+	}, nil
+}
+
+// printDeadLetter writes a message with its failure details and headers to stdout
+func printDeadLetter(letter *messaging.DeadLetter, showBody bool) {
+	fmt.Printf("%4d  %-36s attempts=%d original_queue=%s failed_at=%s\n", letter.Position, displayID(letter), letter.Attempts, letter.OriginalQueue, letter.FailedAt)
+	fmt.Printf("      error: %s\n", letter.LastError)
+	for key, value := range letter.Headers {
+		fmt.Printf("      %s: %v\n", key, value)
+	}
+	if showBody {
+		fmt.Printf("      body: %s\n", letter.Body)
+	}
+}
+
+// keepDeadLetter puts a message back on the dead-letter queue
+func keepDeadLetter(letter *messaging.DeadLetter) error {
+	if err := letter.Keep(); err != nil {
+		return fmt.Errorf("failed to put message %d back on the dead-letter queue: %v", letter.Position, err)
+	}
+	return nil
+}
+
+func displayID(letter *messaging.DeadLetter) string {
+	if letter.MessageID == "" {
+		return "(no message id)"
+	}
+	return letter.MessageID
+}
+
+func pastTense(action string) string {
+	if action == "replay" {
+		return "replayed"
+	}
+	return "purged"
+}
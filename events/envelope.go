@@ -0,0 +1,70 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SpecVersion is the CloudEvents version the envelope follows
+const SpecVersion = "1.0"
+
+// Source identifies this service as the producer of a message
+const Source = "/bingebuster/user-service"
+
+// ContentType is the content type of a message carrying an envelope, the data inside is JSON
+const ContentType = "application/cloudevents+json"
+
+// Envelope wraps every message the service produces, in the structured format of CloudEvents. Type says what
+// the message is about, e.g. "user.updated" or "getAllRecords", and Data holds the payload of that type.
+type Envelope struct {
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	SpecVersion     string          `json:"specversion"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEnvelope wraps the JSON encoding of data in an envelope of the given type with a new id, a nil data
+// leaves the envelope without data
+func NewEnvelope(messageType string, data interface{}) (*Envelope, error) {
+	envelope := &Envelope{
+		ID:          primitive.NewObjectID().Hex(),
+		Type:        messageType,
+		Source:      Source,
+		SpecVersion: SpecVersion,
+		Time:        time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if data != nil {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s data: %w", messageType, err)
+		}
+		envelope.DataContentType, envelope.Data = "application/json", payload
+	}
+	return envelope, nil
+}
+
+// NewOutboxMessage builds an outbox message carrying the envelope, the message shares the id of the envelope.
+// The caller fills in where the message goes.
+func NewOutboxMessage(envelope *Envelope) (*outbox.Message, error) {
+	id, err := primitive.ObjectIDFromHex(envelope.ID)
+	if err != nil {
+		return nil, fmt.Errorf("envelope id %q is not an ObjectId: %w", envelope.ID, err)
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s envelope: %w", envelope.Type, err)
+	}
+	return &outbox.Message{
+		ID:          id,
+		ContentType: ContentType,
+		Payload:     payload,
+		CreatedAt:   envelope.Time,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+)
+
+// Event types
+const (
+	TypeCreated  = "user.created"
+	TypeUpdated  = "user.updated"
+	TypeDeleted  = "user.deleted"
+	TypeRestored = "user.restored"
+	TypePurged   = "user.purged"
+	// TypeErased is published once every downstream service confirmed that it erased the user as well
+	TypeErased = "user.erased"
+)
+
+// UserEvent is the data of the envelope published for every change to a user. It names the changed fields
+// rather than carrying their values, consumers that need the data read it through the API.
+type UserEvent struct {
+	UserID         string   `json:"user_id,omitempty"`
+	ExternalUserID string   `json:"external_user_id,omitempty"`
+	ChangedFields  []string `json:"changed_fields,omitempty"`
+}
+
+// NewUserEventMessage builds the outbox message for a change from before to after, either of which may be nil.
+// It's published to the topic exchange with the event type as routing key, so services can subscribe to e.g.
+// "user.deleted" or "user.#".
+func NewUserEventMessage(exchange, eventType string, before, after *models.User) (*outbox.Message, error) {
+	event := UserEvent{}
+	for _, user := range []*models.User{after, before} {
+		if user == nil {
+			continue
+		}
+		if event.UserID == "" && !user.ID.IsZero() {
+			event.UserID = user.ID.Hex()
+		}
+		if event.ExternalUserID == "" {
+			event.ExternalUserID = user.UserID
+		}
+	}
+	for _, change := range audit.Diff(before, after) {
+		event.ChangedFields = append(event.ChangedFields, change.Field)
+	}
+
+	envelope, err := NewEnvelope(eventType, event)
+	if err != nil {
+		return nil, err
+	}
+	message, err := NewOutboxMessage(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keying on the user keeps the events of one user in order, the external id is preferred since
+	// deletions (and the deletion messages to other services) only know that one
+	key := event.UserID
+	if event.ExternalUserID != "" {
+		key = event.ExternalUserID
+	}
+	message.Key = key
+	message.Exchange = exchange
+	message.RoutingKey = eventType
+	return message, nil
+}
@@ -0,0 +1,125 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/userdata"
+)
+
+// manifestName is the file in the root of the archive describing its contents
+const manifestName = "manifest.json"
+
+// Manifest describes an export archive, it lists every source with its status and the files holding its data
+type Manifest struct {
+	ExportID    string           `json:"export_id"`
+	UserID      string           `json:"user_id"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Sources     []ManifestSource `json:"sources"`
+}
+
+// ManifestSource describes the data of a single source
+type ManifestSource struct {
+	Source      string         `json:"source"`
+	Status      string         `json:"status"`
+	CollectedAt time.Time      `json:"collected_at"`
+	Error       string         `json:"error,omitempty"`
+	Files       []ManifestFile `json:"files,omitempty"`
+}
+
+// ManifestFile is a file in the archive with its checksum
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BuildArchive creates the ZIP archive of a job. Every source that answered gets a directory with its data as
+// JSON and CSV, data that isn't valid JSON is stored as is. The manifest lists all sources, including the ones
+// that failed or didn't answer in time.
+func BuildArchive(job *Job, sections []userdata.Section, generatedAt time.Time) ([]byte, *Manifest, error) {
+	manifest := &Manifest{ExportID: job.ID.Hex(), UserID: job.UserID, GeneratedAt: generatedAt}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, section := range sections {
+		entry := ManifestSource{
+			Source:      section.Source,
+			Status:      section.Status.String(),
+			CollectedAt: section.CollectedAt,
+			Error:       section.Err,
+		}
+
+		var files []archiveFile
+		switch {
+		case section.Payload != nil:
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, section.Payload, "", "  "); err != nil {
+				return nil, nil, fmt.Errorf("failed to format data of %s: %v", section.Source, err)
+			}
+			table, err := toCSV(section.Payload)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to convert data of %s to CSV: %v", section.Source, err)
+			}
+			files = append(files,
+				archiveFile{name: section.Source + "/data.json", format: "json", data: indented.Bytes()},
+				archiveFile{name: section.Source + "/data.csv", format: "csv", data: table},
+			)
+		case section.Raw != nil:
+			files = append(files, archiveFile{name: section.Source + "/data.txt", format: "raw", data: section.Raw})
+		}
+
+		for _, file := range files {
+			if err := writeFile(archive, file.name, file.data, generatedAt); err != nil {
+				return nil, nil, err
+			}
+			entry.Files = append(entry.Files, ManifestFile{
+				Name:   file.name,
+				Format: file.format,
+				Size:   len(file.data),
+				SHA256: checksum(file.data),
+			})
+		}
+		manifest.Sources = append(manifest.Sources, entry)
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeFile(archive, manifestName, encoded, generatedAt); err != nil {
+		return nil, nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), manifest, nil
+}
+
+// archiveFile is a file waiting to be written to the archive
+type archiveFile struct {
+	name   string
+	format string
+	data   []byte
+}
+
+func writeFile(archive *zip.Writer, name string, data []byte, modified time.Time) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// checksum returns the hex encoded SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"sort"
+)
+
+// scalarColumn is the column holding a payload that is a single value rather than an object
+const scalarColumn = "value"
+
+// toCSV converts a JSON payload into a table. An array becomes one row per element, anything else a single row.
+// Nested objects are flattened into dotted column names, nested arrays are kept as JSON in their cell.
+func toCSV(payload json.RawMessage) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// Keep numbers as written, converting them to float64 would mangle large ids
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]string
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			rows = append(rows, flatten(item))
+		}
+	} else {
+		rows = append(rows, flatten(value))
+	}
+
+	// The header is the union of the columns of all rows
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(columns); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			record[i] = row[column]
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// flatten turns a decoded JSON value into a single row
+func flatten(value interface{}) map[string]string {
+	row := make(map[string]string)
+	if _, ok := value.(map[string]interface{}); !ok {
+		row[scalarColumn] = cell(value)
+		return row
+	}
+	flattenInto(row, "", value)
+	return row
+}
+
+func flattenInto(row map[string]string, prefix string, value interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		row[prefix] = cell(value)
+		return
+	}
+	for key, child := range object {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flattenInto(row, key, child)
+	}
+}
+
+// cell formats a single value, strings are written without quotes and null as an empty cell
+func cell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
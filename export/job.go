@@ -0,0 +1,66 @@
+package export
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned when no export job matches the given id
+var ErrNotFound = errors.New("export not found")
+
+// ErrActiveExport is returned when the user already has an export that is pending or running
+var ErrActiveExport = errors.New("an export of the user is already in progress")
+
+// Job is a request to export all data of a user. The worker picks up pending jobs and stores the resulting
+// archive along with the job until it expires.
+type Job struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	UserID string             `bson:"userid"`
+	// RequestedBy is the actor that requested the export
+	RequestedBy string `bson:"requestedby"`
+	Status      string `bson:"status"`
+	// Active is set while the job is pending or running, so a user has at most one export in progress
+	Active      bool      `bson:"active,omitempty"`
+	CreatedAt   time.Time `bson:"createdat"`
+	StartedAt   time.Time `bson:"startedat,omitempty"`
+	CompletedAt time.Time `bson:"completedat,omitempty"`
+	// ExpiresAt is when the job and its archive are removed, it's set once the job has completed or failed
+	ExpiresAt time.Time `bson:"expiresat,omitempty"`
+	// Attempts counts how often the worker started on the job
+	Attempts int `bson:"attempts"`
+	// Size and SHA256 describe the archive
+	Size   int64  `bson:"size,omitempty"`
+	SHA256 string `bson:"sha256,omitempty"`
+	// IncompleteSources lists the downstream services that failed or didn't reply in time
+	IncompleteSources []string `bson:"incompletesources,omitempty"`
+	Error             string   `bson:"error,omitempty"`
+}
+
+// Result describes the archive of a completed job
+type Result struct {
+	Size              int64
+	SHA256            string
+	IncompleteSources []string
+	CompletedAt       time.Time
+	ExpiresAt         time.Time
+}
+
+// ArchiveName is the file name the archive of the job is offered under
+func (j *Job) ArchiveName() string {
+	return "user-data-" + j.UserID + "-" + j.ID.Hex() + ".zip"
+}
+
+// Expired reports whether the job has passed its expiry date at now
+func (j *Job) Expired(now time.Time) bool {
+	return !j.ExpiresAt.IsZero() && !j.ExpiresAt.After(now)
+}
@@ -0,0 +1,169 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps the jobs and archives in memory, it's meant for tests and local development
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs []*Job
+	// archives are keyed by job id
+	archives map[primitive.ObjectID][]byte
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{archives: make(map[primitive.ObjectID][]byte)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.jobs {
+		if stored.Active && stored.UserID == job.UserID {
+			return ErrActiveExport
+		}
+	}
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+	job.Status = StatusPending
+	job.Active = true
+	stored := *job
+	s.jobs = append(s.jobs, &stored)
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	return s.find(func(job *Job) bool { return job.ID == id })
+}
+
+func (s *MemoryStore) FindActive(ctx context.Context, userID string) (*Job, error) {
+	return s.find(func(job *Job) bool { return job.Active && job.UserID == userID })
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, now, staleBefore time.Time) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Jobs are appended in order, so the first match is the oldest
+	for _, job := range s.jobs {
+		stale := job.Status == StatusRunning && job.StartedAt.Before(staleBefore)
+		if job.Status != StatusPending && !stale {
+			continue
+		}
+		job.Status = StatusRunning
+		job.StartedAt = now
+		job.Attempts++
+		claimed := *job
+		return &claimed, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) Requeue(ctx context.Context, id primitive.ObjectID, reason string) error {
+	return s.update(id, func(job *Job) {
+		if job.Status == StatusRunning {
+			job.Status = StatusPending
+			job.Error = reason
+		}
+	})
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, id primitive.ObjectID, archive []byte, result Result) error {
+	return s.update(id, func(job *Job) {
+		s.archives[id] = append([]byte(nil), archive...)
+		job.Status = StatusCompleted
+		job.Active = false
+		job.CompletedAt = result.CompletedAt
+		job.ExpiresAt = result.ExpiresAt
+		job.Size = result.Size
+		job.SHA256 = result.SHA256
+		job.IncompleteSources = result.IncompleteSources
+		job.Error = ""
+	})
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, id primitive.ObjectID, reason string, at, expiresAt time.Time) error {
+	return s.update(id, func(job *Job) {
+		job.Status = StatusFailed
+		job.Active = false
+		job.Error = reason
+		job.CompletedAt = at
+		job.ExpiresAt = expiresAt
+	})
+}
+
+func (s *MemoryStore) OpenArchive(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	archive, ok := s.archives[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(archive)), nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return s.deleteMany(func(job *Job) bool { return job.Expired(now) }), nil
+}
+
+func (s *MemoryStore) DeleteByUser(ctx context.Context, userID string) (int64, error) {
+	return s.deleteMany(func(job *Job) bool { return job.UserID == userID }), nil
+}
+
+// deleteMany removes the matching jobs along with their archives
+func (s *MemoryStore) deleteMany(match func(*Job) bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	remaining := s.jobs[:0]
+	for _, job := range s.jobs {
+		if match(job) {
+			delete(s.archives, job.ID)
+			deleted++
+			continue
+		}
+		remaining = append(remaining, job)
+	}
+	s.jobs = remaining
+	return deleted
+}
+
+func (s *MemoryStore) find(match func(*Job) bool) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if match(job) {
+			found := *job
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) update(id primitive.ObjectID, fn func(*Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if job.ID == id {
+			fn(job)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
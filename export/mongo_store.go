@@ -0,0 +1,232 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ Store = (*MongoStore)(nil)
+
+// MongoStore keeps the jobs in a MongoDB collection and their archives in GridFS, archives can easily exceed
+// the size limit of a single document
+type MongoStore struct {
+	collection *mongo.Collection
+	archives   *gridfs.Bucket
+}
+
+// NewMongoStore creates a store backed by the given collection, the archives are kept in a GridFS bucket
+// named after it
+func NewMongoStore(collection *mongo.Collection) (*MongoStore, error) {
+	archives, err := gridfs.NewBucket(collection.Database(), options.GridFSBucket().SetName(collection.Name()+"_archives"))
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{collection: collection, archives: archives}, nil
+}
+
+// EnsureIndexes creates the indexes used to claim jobs and find expired ones, and the unique index that
+// allows a single active export per user
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresat", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{
+			Keys: bson.D{{Key: "userid", Value: 1}},
+			Options: options.Index().
+				SetName("userid_active_unique").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"active": true}),
+		},
+	})
+	return err
+}
+
+func (s *MongoStore) Create(ctx context.Context, job *Job) error {
+	job.Status = StatusPending
+	job.Active = true
+	result, err := s.collection.InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrActiveExport
+	}
+	if err != nil {
+		return err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *MongoStore) Get(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	return decodeJob(s.collection.FindOne(ctx, bson.M{"_id": id}))
+}
+
+func (s *MongoStore) FindActive(ctx context.Context, userID string) (*Job, error) {
+	return decodeJob(s.collection.FindOne(ctx, bson.M{"userid": userID, "active": true}))
+}
+
+func (s *MongoStore) Claim(ctx context.Context, now, staleBefore time.Time) (*Job, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": StatusPending},
+		bson.M{"status": StatusRunning, "startedat": bson.M{"$lt": staleBefore}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": StatusRunning, "startedat": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+	return decodeJob(s.collection.FindOneAndUpdate(ctx, filter, update, opts))
+}
+
+func (s *MongoStore) Requeue(ctx context.Context, id primitive.ObjectID, reason string) error {
+	update := bson.M{"$set": bson.M{"status": StatusPending, "error": reason}}
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": id, "status": StatusRunning}, update)
+	return err
+}
+
+func (s *MongoStore) Complete(ctx context.Context, id primitive.ObjectID, archive []byte, result Result) error {
+	// A previous attempt may have stored an archive before it died, replace it
+	if err := s.archives.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+	if err := s.upload(ctx, id, archive); err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":            StatusCompleted,
+			"completedat":       result.CompletedAt,
+			"expiresat":         result.ExpiresAt,
+			"size":              result.Size,
+			"sha256":            result.SHA256,
+			"incompletesources": result.IncompleteSources,
+		},
+		"$unset": bson.M{"active": "", "error": ""},
+	}
+	updated, err := s.collection.UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+	if updated.MatchedCount == 0 {
+		// The job was removed while the archive was built, e.g. because the user was purged
+		if err := s.archives.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+		return ErrNotFound
+	}
+	return nil
+}
+
+// upload stores the archive in GridFS. GridFS streams don't take a context, so the upload is given its deadline
+// and is abandoned when the context is done.
+func (s *MongoStore) upload(ctx context.Context, id primitive.ObjectID, archive []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stream, err := s.archives.OpenUploadStreamWithID(id, id.Hex()+".zip")
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := stream.SetWriteDeadline(deadline); err != nil {
+			stream.Abort()
+			return err
+		}
+	}
+	if _, err := io.Copy(stream, bytes.NewReader(archive)); err != nil {
+		stream.Abort()
+		return err
+	}
+	// Don't finish an upload the caller gave up on, the chunks written so far are removed
+	if err := ctx.Err(); err != nil {
+		stream.Abort()
+		return err
+	}
+	return stream.Close()
+}
+
+func (s *MongoStore) Fail(ctx context.Context, id primitive.ObjectID, reason string, at, expiresAt time.Time) error {
+	update := bson.M{
+		"$set":   bson.M{"status": StatusFailed, "error": reason, "completedat": at, "expiresat": expiresAt},
+		"$unset": bson.M{"active": ""},
+	}
+	_, err := s.collection.UpdateByID(ctx, id, update)
+	return err
+}
+
+func (s *MongoStore) OpenArchive(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, error) {
+	stream, err := s.archives.OpenDownloadStream(id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// GridFS streams don't take a context, so carry over its deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := stream.SetReadDeadline(deadline); err != nil {
+			stream.Close()
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+func (s *MongoStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return s.deleteMany(ctx, bson.M{"expiresat": bson.M{"$lte": now}})
+}
+
+func (s *MongoStore) DeleteByUser(ctx context.Context, userID string) (int64, error) {
+	return s.deleteMany(ctx, bson.M{"userid": userID})
+}
+
+// deleteMany removes the jobs matching the filter along with their archives
+func (s *MongoStore) deleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var jobs []Job
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return 0, err
+	}
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+
+	// Remove the archives first, so a failure never leaves an archive behind without its job
+	ids := make(bson.A, 0, len(jobs))
+	for _, job := range jobs {
+		if err := s.archives.DeleteContext(ctx, job.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return 0, err
+		}
+		ids = append(ids, job.ID)
+	}
+	result, err := s.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// decodeJob decodes a single result and translates a missing document into ErrNotFound
+func decodeJob(result *mongo.SingleResult) (*Job, error) {
+	job := &Job{}
+	if err := result.Decode(job); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,38 @@
+package export
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store keeps the export jobs and their archives
+type Store interface {
+	// Create stores a new pending job and fills in the generated ID, it returns ErrActiveExport when the user
+	// already has a pending or running job
+	Create(ctx context.Context, job *Job) error
+	// Get returns the job with the given id
+	Get(ctx context.Context, id primitive.ObjectID) (*Job, error)
+	// FindActive returns the pending or running job of the user
+	FindActive(ctx context.Context, userID string) (*Job, error)
+	// Claim marks the oldest pending job as running and returns it. Jobs that have been running since before
+	// staleBefore are claimed again, their worker is assumed to have died. It returns ErrNotFound when there's
+	// nothing to do.
+	Claim(ctx context.Context, now, staleBefore time.Time) (*Job, error)
+	// Requeue puts a running job back to pending after a failed attempt
+	Requeue(ctx context.Context, id primitive.ObjectID, reason string) error
+	// Complete stores the archive of the job and marks it completed. It returns ErrNotFound, without keeping the
+	// archive, when the job was removed in the meantime.
+	Complete(ctx context.Context, id primitive.ObjectID, archive []byte, result Result) error
+	// Fail marks the job as failed for good, it's removed at expiresAt
+	Fail(ctx context.Context, id primitive.ObjectID, reason string, at, expiresAt time.Time) error
+	// OpenArchive returns the archive of a completed job
+	OpenArchive(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, error)
+	// DeleteExpired removes the jobs that expired at now along with their archives and returns how many were removed
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+	// DeleteByUser removes every job of the user along with their archives and returns how many were removed,
+	// use the context of a transaction to remove them along with the user
+	DeleteByUser(ctx context.Context, userID string) (int64, error)
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/userdata"
+)
+
+const (
+	// staleAfter is how long a job can be running before another worker takes it over
+	staleAfter = 15 * time.Minute
+	// maxAttempts is how often a job is started before it's given up on
+	maxAttempts = 3
+)
+
+// Worker builds the archives of pending export jobs
+type Worker struct {
+	store     Store
+	collector *userdata.Collector
+	interval  time.Duration
+	// retention is how long archives, and failed jobs, are kept
+	retention time.Duration
+}
+
+// NewWorker creates a worker that checks for pending jobs every interval and keeps archives for retention
+func NewWorker(store Store, collector *userdata.Collector, interval, retention time.Duration) *Worker {
+	return &Worker{store: store, collector: collector, interval: interval, retention: retention}
+}
+
+// Run processes jobs and removes expired ones until the context is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.ProcessPending(ctx); err != nil {
+			log.Printf("Processing data exports failed: %v", err)
+		}
+		if deleted, err := w.store.DeleteExpired(ctx, time.Now().UTC()); err != nil {
+			log.Printf("Removing expired data exports failed: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Removed %d expired data export(s)", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessPending builds the archive of every pending job, one at a time
+func (w *Worker) ProcessPending(ctx context.Context) error {
+	for {
+		now := time.Now().UTC()
+		job, err := w.store.Claim(ctx, now, now.Add(-staleAfter))
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := w.process(ctx, job); err != nil {
+			return err
+		}
+	}
+}
+
+// process builds and stores the archive of a claimed job. Errors that may go away on their own put the job back
+// in the queue until it runs out of attempts, and are returned so the round stops and the job is retried on the
+// next tick instead of right away.
+func (w *Worker) process(ctx context.Context, job *Job) error {
+	if job.Attempts > maxAttempts {
+		return w.fail(ctx, job, fmt.Sprintf("gave up after %d attempts: %s", maxAttempts, job.Error))
+	}
+
+	sections, err := w.collector.Collect(ctx, job.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		// The user was purged after the export was requested, there's nothing left to export
+		return w.fail(ctx, job, "user not found")
+	}
+	if err != nil {
+		return w.retry(ctx, job, fmt.Errorf("failed to collect user data: %v", err))
+	}
+
+	now := time.Now().UTC()
+	archive, manifest, err := BuildArchive(job, sections, now)
+	if err != nil {
+		return w.fail(ctx, job, fmt.Sprintf("failed to build archive: %v", err))
+	}
+
+	// Partial exports are still delivered, the manifest and the job tell which sources are missing
+	var incomplete []string
+	for _, source := range manifest.Sources {
+		if source.Status != messaging.ReplyAnswered.String() {
+			incomplete = append(incomplete, source.Source)
+		}
+	}
+	err = w.store.Complete(ctx, job.ID, archive, Result{
+		Size:              int64(len(archive)),
+		SHA256:            checksum(archive),
+		IncompleteSources: incomplete,
+		CompletedAt:       now,
+		ExpiresAt:         now.Add(w.retention),
+	})
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Data export %s was removed while it ran, its user was purged", job.ID.Hex())
+		return nil
+	}
+	if err != nil {
+		return w.retry(ctx, job, fmt.Errorf("failed to store archive: %v", err))
+	}
+
+	log.Printf("Exported data of user %s (export %s, %d bytes)", job.UserID, job.ID.Hex(), len(archive))
+	return nil
+}
+
+// retry puts the job back in the queue, it's given up on once it runs out of attempts
+func (w *Worker) retry(ctx context.Context, job *Job, reason error) error {
+	log.Printf("Data export %s failed on attempt %d: %v", job.ID.Hex(), job.Attempts, reason)
+	if job.Attempts >= maxAttempts {
+		return w.fail(ctx, job, reason.Error())
+	}
+	if err := w.store.Requeue(ctx, job.ID, reason.Error()); err != nil {
+		return err
+	}
+	return reason
+}
+
+func (w *Worker) fail(ctx context.Context, job *Job, reason string) error {
+	log.Printf("Data export %s of user %s failed: %s", job.ID.Hex(), job.UserID, reason)
+	now := time.Now().UTC()
+	return w.store.Fail(ctx, job.ID, reason, now, now.Add(w.retention))
+}
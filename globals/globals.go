@@ -8,7 +8,6 @@ import (
 
 var (
 	Db          *mongo.Client
-	UserDb      *mongo.Collection
 	MongoCtx    context.Context
 	MongoDBUrl  string
 	RabbitMQUrl string
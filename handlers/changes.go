@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
+	"google.golang.org/grpc/metadata"
+)
+
+// actorMetadataKey is the gRPC metadata key callers use to say on whose behalf they make a change
+const actorMetadataKey = "x-actor-id"
+
+// anonymousActor is recorded when the caller doesn't identify itself
+const anonymousActor = "anonymous"
+
+// actorFromContext returns the actor named in the incoming gRPC metadata
+func actorFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return anonymousActor
+	}
+	if values := md.Get(actorMetadataKey); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return anonymousActor
+}
+
+// originOf describes a change made through the given RPC for the audit trail
+func originOf(ctx context.Context, rpc string) service.Origin {
+	return service.Origin{Actor: actorFromContext(ctx), Action: rpc, Source: audit.SourceGRPC}
+}
@@ -2,12 +2,11 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/globals"
-	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
 	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -18,22 +17,16 @@ func (s *UserServiceServer) CreateUser(ctx context.Context, req *userpb.CreateUs
 	if user == nil {
 		return nil, status.Error(codes.InvalidArgument, "Invalid user")
 	}
-	// Now we have to convert this into a User type to convert into BSON
-	data := models.User{
-		// ID:    Empty, so it gets omitted and MongoDB generates a unique Object ID upon insertion.
-		Email:            user.GetEmail(),
-		Phone:            user.GetPhone(),
-		DateOfBirth:      user.GetDateOfBirth(),
-		FirstName:        user.GetFirstName(),
-		LastName:         user.GetLastName(),
-		CreditCardNumber: user.GetCreditCardNumber(),
-		ExpirationDate:   user.GetExpirationDate(),
-		CVC:              user.GetCvc(),
-	}
+	// Now we have to convert this into a User type to convert into BSON, the ID is left empty so MongoDB
+	// generates a unique Object ID upon insertion
+	data := userFromProto(user)
 
-	// Insert the data into the database, result contains the newly generated Object ID for the new document
-	result, err := globals.UserDb.InsertOne(globals.MongoCtx, data)
+	// Store the user along with its audit entry and user.created event, the service fills in the newly generated Object ID
+	err := s.service.Create(ctx, originOf(ctx, "CreateUser"), data)
 	// check for potential errors
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		return nil, alreadyExistsError("user.email", fmt.Sprintf("A user with email %s already exists", data.Email))
+	}
 	if err != nil {
 		// return internal gRPC error to be handled later
 		return nil, status.Errorf(
@@ -41,10 +34,6 @@ func (s *UserServiceServer) CreateUser(ctx context.Context, req *userpb.CreateUs
 			fmt.Sprintf("Internal error: %v", err),
 		)
 	}
-	// add the id to movie, first cast the "generic type" (go doesn't have real generics yet) to an Object ID.
-	oid := result.InsertedID.(primitive.ObjectID)
-	// Convert the object id to it's string counterpart
-	user.Id = oid.Hex()
-	// return the blog in a CreateMovieRes type
-	return &userpb.CreateUserRes{User: user}, nil
+	// return the stored user (with its generated id, normalized email and version) in a CreateUserRes type
+	return &userpb.CreateUserRes{User: userToProto(data)}, nil
 }
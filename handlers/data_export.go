@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/export"
+	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// downloadChunkSize is the size of the archive parts streamed by DownloadDataExport
+const downloadChunkSize = 64 * 1024
+
+// exportStatuses maps the status of a job onto its protobuf counterpart
+var exportStatuses = map[string]userpb.DataExportStatus{
+	export.StatusPending:   userpb.DataExportStatus_DATA_EXPORT_STATUS_PENDING,
+	export.StatusRunning:   userpb.DataExportStatus_DATA_EXPORT_STATUS_RUNNING,
+	export.StatusCompleted: userpb.DataExportStatus_DATA_EXPORT_STATUS_COMPLETED,
+	export.StatusFailed:    userpb.DataExportStatus_DATA_EXPORT_STATUS_FAILED,
+}
+
+func (s *UserServiceServer) RequestDataExport(ctx context.Context, req *userpb.RequestDataExportReq) (*userpb.RequestDataExportRes, error) {
+	if req.GetUserId() == "" {
+		return nil, invalidFieldError("user_id", "user_id is required")
+	}
+
+	// Users pending deletion can still request their data
+	if _, err := s.users.FindByUserID(ctx, req.GetUserId(), repository.IncludeDeleted); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "Could not find user with supplied ID %s", req.GetUserId())
+		}
+		return nil, status.Errorf(codes.Internal, "Internal error: %v", err)
+	}
+
+	// The worker picks up the job, the caller polls GetDataExportStatus until it's done
+	job := &export.Job{
+		UserID:      req.GetUserId(),
+		RequestedBy: actorFromContext(ctx),
+		CreatedAt:   time.Now().UTC(),
+	}
+	err := s.exports.Create(ctx, job)
+	if errors.Is(err, export.ErrActiveExport) {
+		// Asking again while an export is in progress returns that export instead of starting another one
+		job, err = s.exports.FindActive(ctx, req.GetUserId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not request data export: %v", err)
+	}
+	return &userpb.RequestDataExportRes{
+		Export: exportToProto(job),
+	}, nil
+}
+
+func (s *UserServiceServer) GetDataExportStatus(ctx context.Context, req *userpb.GetDataExportStatusReq) (*userpb.GetDataExportStatusRes, error) {
+	job, err := s.findExport(ctx, req.GetExportId())
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.GetDataExportStatusRes{
+		Export: exportToProto(job),
+	}, nil
+}
+
+func (s *UserServiceServer) DownloadDataExport(req *userpb.DownloadDataExportReq, stream userpb.UserService_DownloadDataExportServer) error {
+	ctx := stream.Context()
+	job, err := s.findExport(ctx, req.GetExportId())
+	if err != nil {
+		return err
+	}
+	if job.Status != export.StatusCompleted {
+		return status.Errorf(codes.FailedPrecondition, "Data export %s is %s, only completed exports can be downloaded", req.GetExportId(), job.Status)
+	}
+	if job.Expired(time.Now().UTC()) {
+		return status.Errorf(codes.FailedPrecondition, "Data export %s has expired, request a new one", req.GetExportId())
+	}
+
+	archive, err := s.exports.OpenArchive(ctx, job.ID)
+	if errors.Is(err, export.ErrNotFound) {
+		return status.Errorf(codes.NotFound, "Could not find the archive of data export %s", req.GetExportId())
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not open archive: %v", err)
+	}
+	defer archive.Close()
+
+	// Stream the archive in chunks, so it never has to fit into a single message
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := io.ReadFull(archive, buf)
+		if n > 0 {
+			if err := stream.Send(&userpb.DownloadDataExportRes{Chunk: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "Could not read archive: %v", err)
+		}
+	}
+}
+
+// findExport looks up the job with the given id and translates the failures into a status
+func (s *UserServiceServer) findExport(ctx context.Context, id string) (*export.Job, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, invalidFieldError("export_id", "Could not convert the supplied export id to a MongoDB ObjectId")
+	}
+	job, err := s.exports.Get(ctx, oid)
+	if errors.Is(err, export.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "Could not find data export with supplied ID %s", id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Internal error: %v", err)
+	}
+	return job, nil
+}
+
+// exportToProto converts a stored export job into its protobuf counterpart
+func exportToProto(job *export.Job) *userpb.DataExport {
+	return &userpb.DataExport{
+		Id:                job.ID.Hex(),
+		UserId:            job.UserID,
+		Status:            exportStatuses[job.Status],
+		RequestedAt:       timestampOrNil(job.CreatedAt),
+		StartedAt:         timestampOrNil(job.StartedAt),
+		CompletedAt:       timestampOrNil(job.CompletedAt),
+		ExpiresAt:         timestampOrNil(job.ExpiresAt),
+		SizeBytes:         job.Size,
+		Sha256:            job.SHA256,
+		IncompleteSources: job.IncompleteSources,
+		Error:             job.Error,
+	}
+}
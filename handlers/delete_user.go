@@ -2,49 +2,34 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 
-	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/globals"
-	messaging "github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
 	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func (s *UserServiceServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserReq) (*userpb.DeleteUserRes, error) {
-	// Create a filter for the userID field
-	filter := bson.M{"userid": req.GetId()}
-
-	// Delete the documents matching the filter
-	result, err := globals.UserDb.DeleteMany(ctx, filter)
-	if err != nil {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Could not find/delete user(s) with id %s: %v", req.GetId(), err))
-	}
-
-	// Check if any documents were deleted
-	if result.DeletedCount == 0 {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("No user(s)) with id %s found: %v", req.GetId(), err))
+	if req.GetId() == "" {
+		return nil, invalidFieldError("id", "id is required")
 	}
 
-	// Send a message to the watch history queue for deleting user's history
-	message := map[string]interface{}{
-		"user_id": req.GetId(),
-		"action":  "deleteAllRecords",
+	// Mark the documents belonging to the user id as deleted, the purger erases them once the grace period has passed.
+	// Deleting a user that is already pending deletion succeeds without extending the grace period.
+	purgeAfter, err := s.service.Delete(ctx, originOf(ctx, "DeleteUser"), req.GetId())
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("No user(s) with id %s found: %v", req.GetId(), err))
 	}
-
-	conn, err := messaging.ConnectToRabbitMQ(globals.RabbitMQUrl)
 	if err != nil {
-		log.Fatalf("Can't connect to RabbitMQ: %s", err)
+		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Could not delete user(s) with id %s: %v", req.GetId(), err))
 	}
 
-	messaging.ProduceMessage(conn, message, "auth_queue")
-	messaging.ProduceMessage(conn, message, "authz_queue")
-	messaging.ProduceMessage(conn, message, "watch_history_queue")
-
-	// Return response with success: true if no error is thrown (and thus document is removed)
+	// Return response with success: true if no error is thrown (and thus the user is pending deletion)
 	return &userpb.DeleteUserRes{
-		Success: true,
+		Success:    true,
+		PurgeAfter: timestamppb.New(purgeAfter),
 	}, nil
 }
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/grpc/codes"
+)
+
+func TestDeleteUser(t *testing.T) {
+	t.Run("without id", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, time.Second)
+		_, err := s.DeleteUser(s.ctx, &userpb.DeleteUserReq{})
+		assertCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("missing user", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, time.Second)
+		_, err := s.DeleteUser(s.ctx, &userpb.DeleteUserReq{Id: "missing"})
+		assertCode(t, err, codes.NotFound)
+	})
+
+	t.Run("marks the user as deleted", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, time.Second)
+		s.createUser(t, "user-1")
+
+		before := time.Now()
+		res, err := s.DeleteUser(s.ctx, &userpb.DeleteUserReq{Id: "user-1"})
+		if err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		purgeAfter := res.GetPurgeAfter().AsTime()
+		if !res.GetSuccess() || purgeAfter.Before(before.Add(time.Hour-time.Second)) {
+			t.Errorf("DeleteUser returned %v, want success with the user purged after an hour", res)
+		}
+
+		if _, err := s.users.FindByUserID(s.ctx, "user-1", repository.ExcludeDeleted); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("FindByUserID of the deleted user returned %v, want ErrNotFound", err)
+		}
+		status, err := s.GetDeletionStatus(s.ctx, &userpb.GetDeletionStatusReq{UserId: "user-1"})
+		if err != nil {
+			t.Fatalf("GetDeletionStatus: %v", err)
+		}
+		if state := status.GetDeletion().GetState(); state != userpb.DeletionState_DELETION_STATE_SCHEDULED {
+			t.Errorf("Deletion is %v, want scheduled", state)
+		}
+
+		// Deleting again keeps the grace period
+		again, err := s.DeleteUser(s.ctx, &userpb.DeleteUserReq{Id: "user-1"})
+		if err != nil {
+			t.Fatalf("DeleteUser again: %v", err)
+		}
+		if !again.GetPurgeAfter().AsTime().Equal(purgeAfter) {
+			t.Errorf("Deleting again moved the purge to %s, want %s", again.GetPurgeAfter().AsTime(), purgeAfter)
+		}
+	})
+
+	t.Run("erases the user everywhere once the grace period passed", func(t *testing.T) {
+		s := newTestServer(t, 0, time.Second)
+		s.createUser(t, "user-1")
+
+		// Subscribe to the user events, and confirm every erasure like the downstream services do
+		if err := s.broker.DeclareExchange(s.ctx, testTopology.Events); err != nil {
+			t.Fatalf("DeclareExchange: %v", err)
+		}
+		if err := s.broker.DeclareQueue(s.ctx, messaging.Queue{Name: "subscriber"}); err != nil {
+			t.Fatalf("DeclareQueue: %v", err)
+		}
+		if err := s.broker.BindQueue(s.ctx, "subscriber", "user.#", testTopology.Events.Name); err != nil {
+			t.Fatalf("BindQueue: %v", err)
+		}
+		var mu sync.Mutex
+		var events []string
+		s.broker.Consume(s.ctx, messaging.Queue{Name: "subscriber"}, messaging.ConsumerOptions{}, func(d amqp.Delivery) error {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, d.RoutingKey)
+			return nil
+		})
+		for _, downstream := range testTopology.Downstream {
+			s.downstream(downstream.Queue, func(d amqp.Delivery) amqp.Publishing {
+				return amqp.Publishing{Body: []byte(`{"deleted":true}`)}
+			})
+		}
+		s.broker.ConsumeReplies(s.ctx, testTopology.DeletionReplies, s.coordinator.HandleReply)
+
+		if _, err := s.DeleteUser(s.ctx, &userpb.DeleteUserReq{Id: "user-1"}); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if err := s.relay.RelayPending(s.ctx); err != nil {
+			t.Fatalf("RelayPending: %v", err)
+		}
+		if err := s.purger.PurgeExpired(s.ctx); err != nil {
+			t.Fatalf("PurgeExpired: %v", err)
+		}
+		if _, err := s.users.FindByUserID(s.ctx, "user-1", repository.IncludeDeleted); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("FindByUserID of the purged user returned %v, want ErrNotFound", err)
+		}
+
+		// The relay sends the purge event and the requests to erase the user, the replies complete the saga
+		if err := s.relay.RelayPending(s.ctx); err != nil {
+			t.Fatalf("RelayPending: %v", err)
+		}
+		var deletion *userpb.DeletionStatus
+		eventually(t, "every service confirmed the erasure", func() bool {
+			res, err := s.GetDeletionStatus(s.ctx, &userpb.GetDeletionStatusReq{UserId: "user-1"})
+			if err != nil {
+				t.Fatalf("GetDeletionStatus: %v", err)
+			}
+			deletion = res.GetDeletion()
+			return deletion.GetState() == userpb.DeletionState_DELETION_STATE_COMPLETED
+		})
+		if len(deletion.GetSteps()) != len(testTopology.Downstream) {
+			t.Fatalf("Deletion has %d step(s), want one per downstream service", len(deletion.GetSteps()))
+		}
+		for _, step := range deletion.GetSteps() {
+			if step.GetStatus() != userpb.DeletionStepStatus_DELETION_STEP_STATUS_CONFIRMED || step.GetAttempts() != 1 {
+				t.Errorf("Step of %s is %v, want confirmed after one attempt", step.GetService(), step)
+			}
+		}
+
+		if err := s.relay.RelayPending(s.ctx); err != nil {
+			t.Fatalf("RelayPending: %v", err)
+		}
+		eventually(t, "the erasure is published", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(events) == 3
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		if events[0] != "user.deleted" || events[1] != "user.purged" || events[2] != "user.erased" {
+			t.Errorf("Published %v, want user.deleted, user.purged and user.erased", events)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// alreadyExistsError builds an AlreadyExists status carrying a BadRequest detail that names the conflicting field
+func alreadyExistsError(field, description string) error {
+	return fieldViolationError(codes.AlreadyExists, field, description)
+}
+
+// invalidFieldError builds an InvalidArgument status carrying a BadRequest detail that names the offending field
+func invalidFieldError(field, description string) error {
+	return fieldViolationError(codes.InvalidArgument, field, description)
+}
+
+func fieldViolationError(code codes.Code, field, description string) error {
+	st := status.New(code, description)
+	detailed, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: description},
+		},
+	})
+	if err != nil {
+		// Fall back to the plain status if the detail can't be attached
+		return st.Err()
+	}
+	return detailed.Err()
+}
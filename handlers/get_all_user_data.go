@@ -2,81 +2,62 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"strings"
-	"sync"
+	"errors"
 
-	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/globals"
 	messaging "github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
 	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/userdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// replyStatuses maps the outcome of a request onto its protobuf counterpart
+var replyStatuses = map[messaging.ReplyStatus]userpb.SourceStatus{
+	messaging.ReplyAnswered: userpb.SourceStatus_SOURCE_STATUS_ANSWERED,
+	messaging.ReplyFailed:   userpb.SourceStatus_SOURCE_STATUS_FAILED,
+	messaging.ReplyTimedOut: userpb.SourceStatus_SOURCE_STATUS_TIMED_OUT,
+}
+
 func (s *UserServiceServer) GetAllUserData(ctx context.Context, req *userpb.GetAllUserDataReq) (*userpb.GetAllUserDataRes, error) {
-	// Connect to RabbitMQ
-	conn, err := messaging.ConnectToRabbitMQ(globals.RabbitMQUrl)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	if req.GetId() == "" {
+		return nil, invalidFieldError("id", "id is required")
 	}
-	defer conn.Close()
-
-	var wg sync.WaitGroup
-	var responseStrings []string
-
-	// Define the callback function for consuming messages
-	consumeCallback := func(body []byte) error {
-		// Append the received string to the response strings slice
-		responseStrings = append(responseStrings, string(body))
 
-		println("the received message" + string(body))
-		// Notify the wait group that a response has been received
-		wg.Done()
-		return nil
+	sections, err := s.userData.Collect(ctx, req.GetId())
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "Could not find user with supplied ID %s", req.GetId())
 	}
-
-	// Start consuming messages from the queue
-	go messaging.ConsumeMessage(conn, "user_data", consumeCallback)
-
-	// Prepare the message
-	message := map[string]interface{}{
-		"user_id": req.GetId(),
-		"action":  "getAllRecords",
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Internal error: %v", err)
 	}
 
-	// Publish the message to the exchange
-	messaging.ProduceMessage(conn, message, "auth_queue")
-	messaging.ProduceMessage(conn, message, "authz_queue")
-	messaging.ProduceMessage(conn, message, "watch_history_queue")
-
-	// Wait for three responses to arrive
-	wg.Add(3)
-	wg.Wait()
-
-	// Combine the response strings into a single string
-	combinedString := strings.Join(responseStrings, "")
-
-	id := req.GetId()
-
-	var result bson.M
-	if err := globals.UserDb.FindOne(ctx, bson.M{"userid": id}).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to find record: %v", err)
+	res := &userpb.GetAllUserDataRes{}
+	for i := range sections {
+		res.Sections = append(res.Sections, sectionToProto(&sections[i]))
 	}
+	return res, nil
+}
 
-	// Convert the result to a JSON string
-	jsonData, err := json.Marshal(result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal record to JSON: %w", err)
+// sectionToProto converts the data of a source into its protobuf counterpart
+func sectionToProto(section *userdata.Section) *userpb.UserDataSection {
+	converted := &userpb.UserDataSection{
+		Source:      section.Source,
+		Status:      replyStatuses[section.Status],
+		CollectedAt: timestampOrNil(section.CollectedAt),
+		RawPayload:  string(section.Raw),
+		Error:       section.Err,
 	}
-
-	// Convert the JSON byte array to a string
-	dataString := string(jsonData)
-
-	// Add something to the combinedString
-	combinedString += dataString
-
-	// Return response with success: true if no error is thrown (and thus document is removed)
-	return &userpb.GetAllUserDataRes{
-		Data: combinedString,
-	}, nil
+	if section.Payload != nil {
+		payload := &structpb.Value{}
+		if err := protojson.Unmarshal(section.Payload, payload); err != nil {
+			// Valid JSON that protobuf can't represent, such as numbers out of range, is passed on as is
+			converted.RawPayload = string(section.Payload)
+		} else {
+			converted.Payload = payload
+		}
+	}
+	return converted
 }
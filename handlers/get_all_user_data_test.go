@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGetAllUserData(t *testing.T) {
+	t.Run("without id", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, time.Second)
+		_, err := s.GetAllUserData(s.ctx, &userpb.GetAllUserDataReq{})
+		assertCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("missing user", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, time.Second)
+		_, err := s.GetAllUserData(s.ctx, &userpb.GetAllUserDataReq{Id: "missing"})
+		assertCode(t, err, codes.NotFound)
+	})
+
+	t.Run("collects every section", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, 200*time.Millisecond)
+		s.createUser(t, "user-1")
+
+		// auth answers with JSON, authz with plain text and watch_history never replies
+		auth, authz := testTopology.Downstream[0].Queue, testTopology.Downstream[1].Queue
+		s.downstream(auth, func(d amqp.Delivery) amqp.Publishing {
+			return amqp.Publishing{Body: []byte(`{"sessions":2}`)}
+		})
+		s.downstream(authz, func(d amqp.Delivery) amqp.Publishing {
+			return amqp.Publishing{Body: []byte("role=admin")}
+		})
+
+		started := time.Now()
+		res, err := s.GetAllUserData(s.ctx, &userpb.GetAllUserDataReq{Id: "user-1"})
+		if err != nil {
+			t.Fatalf("GetAllUserData: %v", err)
+		}
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("GetAllUserData took %s, want it to give up after the timeout", elapsed)
+		}
+
+		sections := res.GetSections()
+		wantSources := []string{"user_profile", "auth", "authz", "watch_history"}
+		if len(sections) != len(wantSources) {
+			t.Fatalf("Got %d section(s), want %d", len(sections), len(wantSources))
+		}
+		for i, source := range wantSources {
+			if sections[i].GetSource() != source {
+				t.Errorf("Section %d is %s, want %s", i, sections[i].GetSource(), source)
+			}
+		}
+
+		profile := sections[0]
+		if profile.GetStatus() != userpb.SourceStatus_SOURCE_STATUS_ANSWERED ||
+			profile.GetPayload().GetStructValue().GetFields()["userid"].GetStringValue() != "user-1" {
+			t.Errorf("Profile section is %v, want the stored user", profile)
+		}
+		if section := sections[1]; section.GetStatus() != userpb.SourceStatus_SOURCE_STATUS_ANSWERED ||
+			section.GetPayload().GetStructValue().GetFields()["sessions"].GetNumberValue() != 2 {
+			t.Errorf("auth section is %v, want its answer", section)
+		}
+		if section := sections[2]; section.GetStatus() != userpb.SourceStatus_SOURCE_STATUS_ANSWERED ||
+			section.GetPayload() != nil || section.GetRawPayload() != "role=admin" {
+			t.Errorf("authz section is %v, want its answer as is", section)
+		}
+		if section := sections[3]; section.GetStatus() != userpb.SourceStatus_SOURCE_STATUS_TIMED_OUT ||
+			section.GetError() == "" || section.GetCollectedAt() == nil {
+			t.Errorf("watch_history section is %v, want a timeout", section)
+		}
+	})
+
+	t.Run("failing service", func(t *testing.T) {
+		s := newTestServer(t, time.Hour, time.Second)
+		s.createUser(t, "user-1")
+		for _, downstream := range testTopology.Downstream {
+			s.downstream(downstream.Queue, func(d amqp.Delivery) amqp.Publishing {
+				return amqp.Publishing{Headers: amqp.Table{messaging.ReplyErrorHeader: "database down"}}
+			})
+		}
+
+		res, err := s.GetAllUserData(s.ctx, &userpb.GetAllUserDataReq{Id: "user-1"})
+		if err != nil {
+			t.Fatalf("GetAllUserData: %v", err)
+		}
+		for _, section := range res.GetSections()[1:] {
+			if section.GetStatus() != userpb.SourceStatus_SOURCE_STATUS_FAILED || section.GetError() != "database down" {
+				t.Errorf("%s section is %v, want the reported failure", section.GetSource(), section)
+			}
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/deletion"
+	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stepStatuses maps the status of a saga step onto its protobuf counterpart
+var stepStatuses = map[string]userpb.DeletionStepStatus{
+	deletion.StepPending:   userpb.DeletionStepStatus_DELETION_STEP_STATUS_PENDING,
+	deletion.StepFailed:    userpb.DeletionStepStatus_DELETION_STEP_STATUS_FAILED,
+	deletion.StepConfirmed: userpb.DeletionStepStatus_DELETION_STEP_STATUS_CONFIRMED,
+}
+
+func (s *UserServiceServer) GetDeletionStatus(ctx context.Context, req *userpb.GetDeletionStatusReq) (*userpb.GetDeletionStatusRes, error) {
+	if req.GetUserId() == "" {
+		return nil, invalidFieldError("user_id", "user_id is required")
+	}
+
+	saga, err := s.deletions.Latest(ctx, req.GetUserId())
+	if err != nil && !errors.Is(err, deletion.ErrSagaNotFound) {
+		return nil, status.Errorf(codes.Internal, "Internal error: %v", err)
+	}
+
+	// A user that is pending deletion hasn't been purged yet, so there's no saga for this deletion
+	user, err := s.users.FindByUserID(ctx, req.GetUserId(), repository.IncludeDeleted)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.Internal, "Internal error: %v", err)
+	}
+	if user != nil && user.IsDeleted() && (saga == nil || saga.PurgedAt.Before(user.DeletedAt)) {
+		return &userpb.GetDeletionStatusRes{
+			Deletion: &userpb.DeletionStatus{
+				UserId:     req.GetUserId(),
+				State:      userpb.DeletionState_DELETION_STATE_SCHEDULED,
+				DeletedAt:  timestampOrNil(user.DeletedAt),
+				PurgeAfter: timestampOrNil(user.PurgeAfter),
+			},
+		}, nil
+	}
+
+	if saga == nil {
+		return nil, status.Errorf(codes.NotFound, "No deletion of user %s found", req.GetUserId())
+	}
+	return &userpb.GetDeletionStatusRes{
+		Deletion: sagaToProto(saga),
+	}, nil
+}
+
+// sagaToProto converts a stored deletion saga into its protobuf counterpart
+func sagaToProto(saga *deletion.Saga) *userpb.DeletionStatus {
+	state := userpb.DeletionState_DELETION_STATE_IN_PROGRESS
+	if saga.Status == deletion.SagaCompleted {
+		state = userpb.DeletionState_DELETION_STATE_COMPLETED
+	}
+
+	steps := make([]*userpb.DeletionStep, 0, len(saga.Steps))
+	for _, step := range saga.Steps {
+		converted := &userpb.DeletionStep{
+			Service:       step.Service,
+			Status:        stepStatuses[step.Status],
+			Attempts:      int32(step.Attempts),
+			LastAttemptAt: timestampOrNil(step.LastAttemptAt),
+			ConfirmedAt:   timestampOrNil(step.ConfirmedAt),
+			Error:         step.Error,
+		}
+		// Confirmed steps aren't attempted again
+		if step.Status != deletion.StepConfirmed {
+			converted.NextAttemptAt = timestampOrNil(step.NextAttemptAt)
+		}
+		steps = append(steps, converted)
+	}
+
+	return &userpb.DeletionStatus{
+		Id:          saga.ID.Hex(),
+		UserId:      saga.UserID,
+		State:       state,
+		DeletedAt:   timestampOrNil(saga.DeletedAt),
+		PurgedAt:    timestampOrNil(saga.PurgedAt),
+		CompletedAt: timestampOrNil(saga.CompletedAt),
+		Steps:       steps,
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *UserServiceServer) ListUserAuditEvents(req *userpb.ListUserAuditEventsReq, stream userpb.UserService_ListUserAuditEventsServer) error {
+	if req.GetUserId() == "" {
+		return invalidFieldError("user_id", "user_id is required")
+	}
+
+	filter := audit.Filter{UserID: req.GetUserId()}
+	if req.GetFrom() != nil {
+		filter.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		filter.To = req.GetTo().AsTime()
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return invalidFieldError("to", "to must be after from")
+	}
+
+	// Stream the entries in chronological order
+	err := s.audit.List(stream.Context(), filter, func(entry *audit.Entry) error {
+		return stream.Send(&userpb.ListUserAuditEventsRes{
+			Event: auditEntryToProto(entry),
+		})
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not list audit events: %v", err)
+	}
+	return nil
+}
+
+// auditEntryToProto converts a stored audit entry into its protobuf counterpart
+func auditEntryToProto(entry *audit.Entry) *userpb.AuditEvent {
+	changes := make([]*userpb.FieldChange, 0, len(entry.Changes))
+	for _, change := range entry.Changes {
+		changes = append(changes, &userpb.FieldChange{
+			Field:  change.Field,
+			Before: change.Before,
+			After:  change.After,
+		})
+	}
+	return &userpb.AuditEvent{
+		Id:             entry.ID.Hex(),
+		UserId:         entry.UserID,
+		ExternalUserId: entry.ExternalUserID,
+		Actor:          entry.Actor,
+		Action:         entry.Action,
+		Source:         entry.Source,
+		Timestamp:      timestamppb.New(entry.Timestamp),
+		Changes:        changes,
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *UserServiceServer) RestoreUser(ctx context.Context, req *userpb.RestoreUserReq) (*userpb.RestoreUserRes, error) {
+	if req.GetId() == "" {
+		return nil, invalidFieldError("id", "id is required")
+	}
+
+	// Undo the soft delete, this only works while the grace period hasn't passed
+	err := s.service.Restore(ctx, originOf(ctx, "RestoreUser"), req.GetId())
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "No deleted user(s) with id %s found", req.GetId())
+	}
+	if errors.Is(err, service.ErrGracePeriodPassed) {
+		return nil, status.Errorf(codes.FailedPrecondition, "The grace period of user %s has passed, it can no longer be restored", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not restore user(s) with id %s: %v", req.GetId(), err)
+	}
+
+	return &userpb.RestoreUserRes{
+		Success: true,
+	}, nil
+}
@@ -2,14 +2,14 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/globals"
 	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
 	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -27,45 +27,32 @@ func (s *UserServiceServer) UpdateUser(ctx context.Context, req *userpb.UpdateUs
 		)
 	}
 
-	// Convert the data to be updated into an unordered Bson document
-	update := bson.M{
-		"email":            user.GetEmail(),
-		"phone":            user.GetPhone(),
-		"dateofbirth":      user.GetDateOfBirth(),
-		"firstname":        user.GetFirstName(),
-		"lastname":         user.GetLastName(),
-		"creditcardnumber": user.GetCreditCardNumber(),
-		"expirationdate":   user.GetExpirationDate(),
-		"cvc":              user.GetCvc(),
+	// Every update has to state which version it was based on so concurrent edits don't overwrite each other
+	if req.GetExpectedVersion() <= 0 {
+		return nil, status.Error(codes.FailedPrecondition, "expected_version is required, read the user first to obtain its current version")
 	}
 
-	// Convert the oid into an unordered bson document to search by id
-	filter := bson.M{"_id": oid}
-
-	// Result is the BSON encoded result
-	// To return the updated document instead of original we have to add options.
-	result := globals.UserDb.FindOneAndUpdate(ctx, filter, bson.M{"$set": update}, options.FindOneAndUpdate().SetReturnDocument(1))
-
-	// Decode result and write it to 'decoded'
-	decoded := models.User{}
-	err = result.Decode(&decoded)
+	// The service merges the fields selected by the mask into the stored user, the updated user is stored
+	// along with its audit entry and user.updated event
+	changes := userFromProto(user)
+	decoded, err := s.service.Update(ctx, originOf(ctx, "UpdateUser"), service.Ref{ID: oid}, req.GetExpectedVersion(), changes, req.GetUpdateMask().GetPaths())
+	var fieldErr *service.FieldError
+	if errors.As(err, &fieldErr) {
+		return nil, invalidFieldError("update_mask.paths", fmt.Sprintf("Invalid update mask: %v", err))
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return nil, status.Errorf(codes.Aborted, "User %s was modified concurrently, expected version %d is no longer current", user.GetId(), req.GetExpectedVersion())
+	}
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		return nil, alreadyExistsError("user.email", fmt.Sprintf("A user with email %s already exists", models.NormalizeEmail(changes.Email)))
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "Could not find user with supplied ID %s", user.GetId())
+	}
 	if err != nil {
-		return nil, status.Errorf(
-			codes.NotFound,
-			fmt.Sprintf("Could not find user with supplied ID: %v", err),
-		)
+		return nil, status.Errorf(codes.Internal, "Internal error: %v", err)
 	}
 	return &userpb.UpdateUserRes{
-		User: &userpb.User{
-			Id:               decoded.ID.Hex(),
-			Email:            decoded.Email,
-			Phone:            decoded.Phone,
-			DateOfBirth:      decoded.DateOfBirth,
-			FirstName:        decoded.FirstName,
-			LastName:         decoded.LastName,
-			CreditCardNumber: decoded.CreditCardNumber,
-			ExpirationDate:   decoded.ExpirationDate,
-			Cvc:              decoded.CVC,
-		},
+		User: userToProto(decoded),
 	}, nil
 }
@@ -1,9 +1,92 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/deletion"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/export"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
 	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/userdata"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type UserServiceServer struct {
 	userpb.UnimplementedUserServiceServer
+
+	// service makes the changes to users, users and audit are only read
+	service *service.UserService
+	users   repository.UserRepository
+	audit   audit.Store
+	// exports keeps the data export jobs, userData gathers the data of a user from all services
+	exports  export.Store
+	userData *userdata.Collector
+	// deletions keeps the sagas erasing users from the downstream services
+	deletions deletion.SagaStore
+}
+
+// Dependencies are the stores the service works with
+type Dependencies struct {
+	Service   *service.UserService
+	Users     repository.UserRepository
+	Audit     audit.Store
+	Exports   export.Store
+	UserData  *userdata.Collector
+	Deletions deletion.SagaStore
+}
+
+// NewUserServiceServer creates the gRPC service on top of the given stores
+func NewUserServiceServer(deps Dependencies) *UserServiceServer {
+	return &UserServiceServer{
+		service:   deps.Service,
+		users:     deps.Users,
+		audit:     deps.Audit,
+		exports:   deps.Exports,
+		userData:  deps.UserData,
+		deletions: deps.Deletions,
+	}
+}
+
+// userToProto converts a stored user into its protobuf counterpart
+func userToProto(user *models.User) *userpb.User {
+	return &userpb.User{
+		Id:               user.ID.Hex(),
+		Email:            user.Email,
+		Phone:            user.Phone,
+		DateOfBirth:      user.DateOfBirth,
+		FirstName:        user.FirstName,
+		LastName:         user.LastName,
+		CreditCardNumber: user.CreditCardNumber,
+		ExpirationDate:   user.ExpirationDate,
+		Cvc:              user.CVC,
+		Version:          user.Version,
+		UpdatedAt:        timestampOrNil(user.UpdatedAt),
+		DeletedAt:        timestampOrNil(user.DeletedAt),
+		PurgeAfter:       timestampOrNil(user.PurgeAfter),
+	}
+}
+
+// userFromProto converts the fields a client can set into a user
+func userFromProto(user *userpb.User) *models.User {
+	return &models.User{
+		Email:            user.GetEmail(),
+		Phone:            user.GetPhone(),
+		DateOfBirth:      user.GetDateOfBirth(),
+		FirstName:        user.GetFirstName(),
+		LastName:         user.GetLastName(),
+		CreditCardNumber: user.GetCreditCardNumber(),
+		ExpirationDate:   user.GetExpirationDate(),
+		CVC:              user.GetCvc(),
+	}
+}
+
+// timestampOrNil converts a time to a protobuf timestamp, leaving unset times empty
+func timestampOrNil(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
 }
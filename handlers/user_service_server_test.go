@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/deletion"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/export"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/userdata"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testTopology mirrors the default topology of the service
+var testTopology = messaging.Topology{
+	UserQueue:       messaging.Queue{Name: "user_queue"},
+	DeletionReplies: messaging.Queue{Name: "user_deletion_replies"},
+	Events:          messaging.Exchange{Name: "user_events", Kind: amqp.ExchangeTopic},
+	Downstream: []messaging.Downstream{
+		{Service: "auth", Queue: messaging.Queue{Name: "auth_queue"}},
+		{Service: "authz", Queue: messaging.Queue{Name: "authz_queue"}},
+		{Service: "watch_history", Queue: messaging.Queue{Name: "watch_history_queue"}},
+	},
+}
+
+// testServer is the service wired up like main does, with the in-memory broker and stores instead of RabbitMQ
+// and MongoDB
+type testServer struct {
+	*UserServiceServer
+	ctx         context.Context
+	broker      *messaging.MemoryBroker
+	users       *repository.MemoryUserRepository
+	relay       *outbox.Relay
+	coordinator *deletion.Coordinator
+	purger      *deletion.Purger
+}
+
+// newTestServer creates a server whose users are purged after gracePeriod and whose downstream services get
+// userDataTimeout to send their data. Its context is cancelled once the test is done.
+func newTestServer(t *testing.T, gracePeriod, userDataTimeout time.Duration) *testServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(func() {
+		cancel()
+		broker.Close(context.Background())
+	})
+
+	users := repository.NewMemoryUserRepository()
+	auditStore := audit.NewMemoryStore()
+	outboxStore := outbox.NewMemoryStore()
+	exportStore := export.NewMemoryStore()
+	sagaStore := deletion.NewMemorySagaStore()
+	tx := repository.NewMemoryTransactor()
+
+	routing := deletion.Routing{ReplyQueue: testTopology.DeletionReplies.Name, EventsExchange: testTopology.Events.Name}
+	for _, downstream := range testTopology.Downstream {
+		routing.Participants = append(routing.Participants, deletion.Participant{Service: downstream.Service, Queue: downstream.Queue.Name})
+	}
+	coordinator := deletion.NewCoordinator(sagaStore, auditStore, outboxStore, tx, time.Minute, routing)
+
+	return &testServer{
+		UserServiceServer: NewUserServiceServer(Dependencies{
+			Service:   service.NewUserService(users, auditStore, outboxStore, tx, gracePeriod, testTopology.Events.Name),
+			Users:     users,
+			Audit:     auditStore,
+			Exports:   exportStore,
+			UserData:  userdata.NewCollector(users, broker, testTopology.Downstream, userDataTimeout),
+			Deletions: sagaStore,
+		}),
+		ctx:         ctx,
+		broker:      broker,
+		users:       users,
+		relay:       outbox.NewRelay(outboxStore, messaging.NewOutboxPublisher(broker, testTopology), time.Minute),
+		coordinator: coordinator,
+		purger:      deletion.NewPurger(users, auditStore, outboxStore, exportStore, tx, coordinator, time.Minute),
+	}
+}
+
+// createUser stores a user with the given id
+func (s *testServer) createUser(t *testing.T, userID string) *models.User {
+	t.Helper()
+	user := &models.User{UserID: userID, Email: userID + "@example.com", FirstName: "Jane"}
+	if err := s.users.Create(s.ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return user
+}
+
+// downstream plays the downstream service on the queue, it replies to every request with what reply returns
+func (s *testServer) downstream(queue messaging.Queue, reply func(d amqp.Delivery) amqp.Publishing) {
+	s.broker.Consume(s.ctx, queue, messaging.ConsumerOptions{}, func(d amqp.Delivery) error {
+		msg := reply(d)
+		msg.CorrelationId = d.CorrelationId
+		return s.broker.Publish(s.ctx, "", d.ReplyTo, false, msg)
+	})
+}
+
+// assertCode fails the test when err doesn't carry the gRPC status code
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("Got %v (%v), want %v", got, err, want)
+	}
+}
+
+// eventually fails the test when the condition doesn't hold within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
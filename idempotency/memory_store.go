@@ -0,0 +1,63 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps the processed messages in memory, it's meant for tests and local development
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+	ttl     time.Duration
+}
+
+// memoryRecord is a claimed or processed message, lockedUntil is only set while it's being processed
+type memoryRecord struct {
+	at          time.Time
+	lockedUntil time.Time
+}
+
+// NewMemoryStore creates an empty in-memory processed-message store
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{records: map[string]memoryRecord{}, ttl: ttl}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string, now time.Time, lease time.Duration) (ClaimResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired records are dropped on read, like the TTL index does in MongoDB, and run out claims are taken over
+	record, ok := s.records[key]
+	if ok && now.Sub(record.at) < s.ttl {
+		if record.lockedUntil.IsZero() {
+			return AlreadyProcessed, nil
+		}
+		if record.lockedUntil.After(now) {
+			return InProgress, nil
+		}
+	}
+	s.records[key] = memoryRecord{at: now, lockedUntil: now.Add(lease)}
+	return Claimed, nil
+}
+
+func (s *MemoryStore) MarkProcessed(ctx context.Context, key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = memoryRecord{at: at}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string, claimedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[key]; ok && !record.lockedUntil.IsZero() && record.at.Equal(claimedAt) {
+		delete(s.records, key)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Record statuses, records written before claims existed have no status and count as processed
+const (
+	statusProcessing = "processing"
+	statusProcessed  = "processed"
+)
+
+var _ Store = (*MongoStore)(nil)
+
+// processedMessage is the document kept for a claimed or processed message, keyed by the message key.
+// ProcessedAt is when it was claimed or processed, the TTL index removes both after the TTL.
+type processedMessage struct {
+	Key         string    `bson:"_id"`
+	Status      string    `bson:"status,omitempty"`
+	ProcessedAt time.Time `bson:"processedat"`
+	LockedUntil time.Time `bson:"lockeduntil,omitempty"`
+}
+
+// MongoStore keeps the processed messages in a MongoDB collection, a TTL index removes them after ttl
+type MongoStore struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+}
+
+// NewMongoStore creates a processed-message store backed by the given collection
+func NewMongoStore(collection *mongo.Collection, ttl time.Duration) *MongoStore {
+	return &MongoStore{collection: collection, ttl: ttl}
+}
+
+// EnsureIndexes creates the TTL index expiring processed messages. MongoDB removes expired documents about
+// once a minute, so Claim also checks the age itself.
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "processedat", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(s.ttl.Seconds())),
+	})
+	return err
+}
+
+func (s *MongoStore) Claim(ctx context.Context, key string, now time.Time, lease time.Duration) (ClaimResult, error) {
+	now = now.UTC()
+	claim := processedMessage{Key: key, Status: statusProcessing, ProcessedAt: now, LockedUntil: now.Add(lease)}
+
+	// The unique _id makes sure only one consumer gets to insert the record
+	_, err := s.collection.InsertOne(ctx, claim)
+	if err == nil {
+		return Claimed, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return 0, err
+	}
+
+	// Take over a record that expired before the TTL index removed it, or a claim whose lease ran out
+	filter := bson.M{"_id": key, "$or": bson.A{
+		bson.M{"processedat": bson.M{"$lte": now.Add(-s.ttl)}},
+		bson.M{"status": statusProcessing, "lockeduntil": bson.M{"$lte": now}},
+	}}
+	result, err := s.collection.ReplaceOne(ctx, filter, claim)
+	if err != nil {
+		return 0, err
+	}
+	if result.MatchedCount > 0 {
+		return Claimed, nil
+	}
+
+	var existing processedMessage
+	if err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&existing); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Released in the meantime, the consumer that had it failed and the message will be retried
+			return InProgress, nil
+		}
+		return 0, err
+	}
+	if existing.Status == statusProcessing {
+		return InProgress, nil
+	}
+	return AlreadyProcessed, nil
+}
+
+func (s *MongoStore) MarkProcessed(ctx context.Context, key string, at time.Time) error {
+	// Upserting keeps marking idempotent as well, e.g. when the same message is processed by two consumers at once
+	record := processedMessage{Key: key, Status: statusProcessed, ProcessedAt: at.UTC()}
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": key}, record, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoStore) Release(ctx context.Context, key string, claimedAt time.Time) error {
+	// Only remove the caller's own claim, not a later one of a consumer that took over
+	filter := bson.M{"_id": key, "status": statusProcessing, "processedat": claimedAt.UTC()}
+	_, err := s.collection.DeleteOne(ctx, filter)
+	return err
+}
@@ -0,0 +1,31 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// ClaimResult tells whether a consumer may process a message
+type ClaimResult int
+
+const (
+	// Claimed means the message is recorded as being processed by the caller
+	Claimed ClaimResult = iota
+	// AlreadyProcessed means the message was processed before and the record hasn't expired yet
+	AlreadyProcessed
+	// InProgress means another consumer claimed the message and its lease hasn't run out
+	InProgress
+)
+
+// Store remembers which messages have been processed. Entries expire after the store's TTL, a message that is
+// redelivered after that is processed again.
+type Store interface {
+	// Claim atomically records that the message with the given key is being processed from now on, unless it
+	// was processed before or another consumer is processing it. A claim whose lease ran out, e.g. because its
+	// consumer crashed, is taken over.
+	Claim(ctx context.Context, key string, now time.Time, lease time.Duration) (ClaimResult, error)
+	// MarkProcessed records that the message with the given key was processed at the given time
+	MarkProcessed(ctx context.Context, key string, at time.Time) error
+	// Release removes the claim made at claimedAt, so a redelivery of the message is processed again
+	Release(ctx context.Context, key string, claimedAt time.Time) error
+}
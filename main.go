@@ -1,19 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
 	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/config"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/deletion"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/export"
 	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/globals"
 	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/handlers"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/idempotency"
 	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/migrations"
 	mongodb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/mongodb"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
 	userpb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/proto"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/userdata"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
@@ -26,6 +46,21 @@ func main() {
 	// Configure 'log' package to give file name and line number on eg. log.Fatal
 	// Pipe flags to one another (log.LstdFLags = log.Ldate | log.Ltime)
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	// Subcommands run a one-off task instead of starting the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrateCommand(c, os.Args[2:])
+			return
+		case "dlq":
+			runDLQCommand(c, os.Args[2:])
+			return
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+	}
+
 	fmt.Println("Starting server on port " + c.Port + "...")
 
 	// Set listener to start server
@@ -38,35 +73,169 @@ func main() {
 	opts := []grpc.ServerOption{}
 	// Create new gRPC server with (blank) options
 	s := grpc.NewServer(opts...)
-	// Create UserService type
-	srv := &handlers.UserServiceServer{}
-
-	// Register the service with the server
-	userpb.RegisterUserServiceServer(s, srv)
 
 	// Construct the MongoDB URL
-	globals.MongoDBUrl = fmt.Sprintf("mongodb+srv://%s:%s@%s", c.MongoDBUser, c.MongoDBPwd, c.MongoDBCluster)
+	globals.MongoDBUrl = mongoDBUrl(c)
 
 	// Initialize MongoDb client
 	fmt.Println("Connecting to MongoDB...")
 	globals.Db = mongodb.ConnectToMongoDB(globals.MongoDBUrl)
 
-	// Bind our collection to our global variable for use in other methods
-	globals.UserDb = globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBCollection)
+	// Bind our collection to the user repository used by the handlers
+	users := repository.NewMongoUserRepository(globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBCollection))
 
-	// Construct the RabbitMQ URL
-	globals.RabbitMQUrl = fmt.Sprintf("amqps://%s:%s@rattlesnake.rmq.cloudamqp.com/%s", c.RabbitMQUser, c.RabbitMQPwd, c.RabbitMQUser)
+	// Bring the stored documents up to date before serving any requests. When another instance is migrating
+	// already, wait for it to finish instead of serving documents that aren't up to date.
+	if c.MigrateOnStartup {
+		migrator := migrations.NewMigrator(globals.Db.Database(c.MongoDBDb), c.MongoDBCollection)
+		if err := migrator.UpWhenUnlocked(context.Background(), migrations.Options{}); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+	}
 
-	//Connect to RabbitMQ
-	fmt.Println("Connecting to RabbitMQ...")
-	conn, err := messaging.ConnectToRabbitMQ(globals.RabbitMQUrl)
+	// Make sure the unique email index exists before accepting any writes
+	if err := users.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create user indexes, run \"migrate up -dry-run\" to list emails used by more than one user: %v", err)
+	}
+
+	// Every change to a user is recorded in the audit trail
+	auditStore := audit.NewMongoStore(globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBAuditCollection))
+	if err := auditStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create audit indexes: %v", err)
+	}
+
+	// Events are written to the outbox in the same transaction as the change they describe
+	tx := repository.NewMongoTransactor(globals.Db)
+	outboxStore := outbox.NewMongoStore(globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBOutboxCollection))
+	if err := outboxStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create outbox indexes: %v", err)
+	}
+
+	// Data exports are built in the background, their archives are kept in GridFS
+	exportStore, err := export.NewMongoStore(globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBExportCollection))
+	if err != nil {
+		log.Fatalf("Failed to create export store: %v", err)
+	}
+	if err := exportStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create export indexes: %v", err)
+	}
+
+	// Deletion sagas track which downstream services confirmed the erasure of a user
+	sagaStore := deletion.NewMongoSagaStore(globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBDeletionCollection))
+	if err := sagaStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create deletion indexes: %v", err)
+	}
+
+	// Consumed messages are remembered for a while, so a redelivered message isn't handled twice
+	processedStore := idempotency.NewMongoStore(globals.Db.Database(c.MongoDBDb).Collection(c.MongoDBProcessedCollection), c.ProcessedMessageTTL)
+	if err := processedStore.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create processed message indexes: %v", err)
+	}
+
+	// Construct the RabbitMQ URL and connection settings, and the queues and exchange the service works with
+	globals.RabbitMQUrl = rabbitMQUrl(c)
+	amqpConfig, err := rabbitMQConfig(c)
 	if err != nil {
-		log.Fatalf("Can't connect to RabbitMQ: %s", err)
+		log.Fatalf("Invalid RabbitMQ settings: %v", err)
+	}
+	topology, err := rabbitMQTopology(c)
+	if err != nil {
+		log.Fatalf("Invalid RabbitMQ topology: %v", err)
+	}
+
+	// Connect to RabbitMQ in the background, the manager keeps reconnecting whenever the connection is lost.
+	// The health service reports NOT_SERVING while there's no connection.
+	fmt.Println("Connecting to RabbitMQ...")
+	rabbitMQ := messaging.NewConnectionManager(globals.RabbitMQUrl, amqpConfig)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	rabbitMQ.OnStateChange(func(state messaging.ConnectionState, err error) {
+		servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+		if state == messaging.StateConnected {
+			servingStatus = healthpb.HealthCheckResponse_SERVING
+		}
+		healthServer.SetServingStatus("", servingStatus)
+		healthServer.SetServingStatus(userpb.UserService_ServiceDesc.ServiceName, servingStatus)
+	})
+	rabbitMQ.Start()
+
+	// All publishes share the manager's connection through a pool of channels and are confirmed by the broker.
+	// Everything below only knows the Broker interface.
+	var broker messaging.Broker = messaging.NewRabbitMQBroker(rabbitMQ, messaging.PublisherOptions{
+		PoolSize:    c.RabbitMQChannelPoolSize,
+		MaxRequests: c.RabbitMQMaxRequests,
+		MaxAttempts: c.RabbitMQPublishAttempts,
+		RetryDelay:  c.RabbitMQPublishRetryDelay,
+	})
+
+	// GetAllUserData and the data exports gather the user's data through the broker
+	userData := userdata.NewCollector(users, broker, topology.Downstream, c.UserDataTimeout)
+
+	// The gRPC handlers and the user queue change users through the same service
+	userService := service.NewUserService(users, auditStore, outboxStore, tx, c.DeletionGracePeriod, topology.Events.Name)
+
+	// Create UserService type
+	srv := handlers.NewUserServiceServer(handlers.Dependencies{
+		Service:   userService,
+		Users:     users,
+		Audit:     auditStore,
+		Exports:   exportStore,
+		UserData:  userData,
+		Deletions: sagaStore,
+	})
+
+	// Register the service with the server
+	userpb.RegisterUserServiceServer(s, srv)
+
+	// The background work below runs until workCtx is cancelled during shutdown, after the gRPC server stopped
+	workCtx, stopWork := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	runInBackground := func(run func(ctx context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run(workCtx)
+		}()
 	}
-	fmt.Println("Connected to RabbitMQ!")
+
+	// Publish the events written to the outbox
+	relay := outbox.NewRelay(outboxStore, messaging.NewOutboxPublisher(broker, topology), c.OutboxRelayInterval)
+	runInBackground(relay.Run)
+
+	// Erase deleted users once their grace period has passed, and make sure the downstream services do the same
+	coordinator := deletion.NewCoordinator(sagaStore, auditStore, outboxStore, tx, c.DeletionRetryInterval, deletionRouting(topology))
+	runInBackground(coordinator.Run)
+	replies := broker.ConsumeReplies(workCtx, topology.DeletionReplies, coordinator.HandleReply)
+	purger := deletion.NewPurger(users, auditStore, outboxStore, exportStore, tx, coordinator, c.PurgeInterval)
+	runInBackground(purger.Run)
+
+	// Build the archives of requested data exports
+	exportWorker := export.NewWorker(exportStore, userData, c.ExportWorkerInterval, c.ExportRetention)
+	runInBackground(exportWorker.Run)
 
 	// Start listening for messages RabbitMQ
-	go messaging.ConsumeMessage(conn, "user_queue", messaging.HandleMessage)
+	// Messages about the same user are handled in order, messages about different users concurrently
+	handler := messaging.NewMessageHandler(userService, broker, topology.UserQueue.Name)
+	userQueue := broker.Consume(workCtx, topology.UserQueue, messaging.ConsumerOptions{
+		Retry:       messaging.RetryPolicy{MaxAttempts: c.ConsumerMaxAttempts, Delay: c.ConsumerRetryDelay},
+		Prefetch:    c.ConsumerPrefetch,
+		Workers:     c.ConsumerWorkers,
+		OrderingKey: handler.OrderingKey,
+	}, messaging.Idempotent(processedStore, topology.UserQueue.Name, handler.HandleMessage))
+
+	// Serve the counters, such as the skipped redeliveries, on /debug/vars. They get their own mux so nothing
+	// else registered on the default one is exposed along with them.
+	var metrics *http.Server
+	if c.MetricsPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		metrics = &http.Server{Addr: c.MetricsPort, Handler: mux}
+		go func() {
+			if err := metrics.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
 
 	go func() {
 		if err := s.Serve(lis); err != nil {
@@ -76,23 +245,164 @@ func main() {
 	fmt.Println("Server succesfully started on port " + c.Port)
 
 	// Right way to stop the server using a SHUTDOWN HOOK
-	// Create a channel to receive OS signals
-	cs := make(chan os.Signal)
+	// Block main routine until CTRL+C (os.Interrupt) or SIGTERM, which is what container runtimes send, is received
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-signalCtx.Done()
+	stopSignals()
 
-	// Relay os.Interrupt to our channel (os.Interrupt = CTRL+C)
-	// Ignore other incoming signals
-	signal.Notify(cs, os.Interrupt)
+	// Everything has to be stopped within the shutdown timeout, whatever is still running after that is cut off
+	fmt.Println("\nStopping the server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
 
-	// Block main routine until a signal is received
-	// As long as user doesn't press CTRL+C a message is not passed and our main routine keeps running
-	<-cs
+	// Stop accepting RPCs and let the running ones finish
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Println("RPCs didn't finish in time, stopping the server")
+		s.Stop()
+	}
+	if metrics != nil {
+		metrics.Shutdown(shutdownCtx)
+	}
+
+	// Stop consuming and let the messages being handled finish, along with the background work
+	fmt.Println("Draining consumers...")
+	stopWork()
+	for _, consumer := range []*messaging.Consumer{userQueue, replies} {
+		if err := consumer.Wait(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+	}
+	workDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(workDone)
+	}()
+	select {
+	case <-workDone:
+	case <-shutdownCtx.Done():
+		log.Println("Background work didn't finish in time")
+	}
+
+	// Wait for the broker to confirm what's still being published, then close the RabbitMQ connection
+	fmt.Println("Closing RabbitMQ connection")
+	if err := broker.Close(shutdownCtx); err != nil {
+		log.Printf("Failed to flush the publisher: %v", err)
+	}
 
-	// After receiving CTRL+C Properly stop the server
-	fmt.Println("\nStopping the server...")
-	s.Stop()
-	lis.Close()
 	fmt.Println("Closing MongoDB connection")
-	globals.Db.Disconnect(globals.MongoCtx)
+	globals.Db.Disconnect(shutdownCtx)
 	fmt.Println("Done.")
 
 }
+
+// mongoDBUrl constructs the MongoDB connection string from the config
+func mongoDBUrl(c config.Config) string {
+	return fmt.Sprintf("mongodb+srv://%s:%s@%s", c.MongoDBUser, c.MongoDBPwd, c.MongoDBCluster)
+}
+
+// rabbitMQUrl constructs the RabbitMQ connection string from the config, the vhost is set by rabbitMQConfig
+func rabbitMQUrl(c config.Config) string {
+	host := c.RabbitMQCluster
+	if c.RabbitMQPort != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(c.RabbitMQPort))
+	}
+	rabbitMQ := url.URL{Scheme: c.RabbitMQScheme, Host: host}
+	if c.RabbitMQUser != "" {
+		rabbitMQ.User = url.UserPassword(c.RabbitMQUser, c.RabbitMQPwd)
+	}
+	return rabbitMQ.String()
+}
+
+// rabbitMQConfig holds the vhost, heartbeat and, for amqps, TLS settings of the RabbitMQ connection
+func rabbitMQConfig(c config.Config) (amqp.Config, error) {
+	if c.RabbitMQScheme != "amqp" && c.RabbitMQScheme != "amqps" {
+		return amqp.Config{}, fmt.Errorf("RABBITMQ_SCHEME must be amqp or amqps, not %q", c.RabbitMQScheme)
+	}
+	if c.RabbitMQCluster == "" {
+		return amqp.Config{}, fmt.Errorf("RABBITMQ_CLUSTER is empty")
+	}
+
+	amqpConfig := amqp.Config{Vhost: rabbitMQVhost(c), Heartbeat: c.RabbitMQHeartbeat, Locale: "en_US"}
+	if c.RabbitMQScheme == "amqps" {
+		tlsConfig, err := messaging.TLSConfig(c.RabbitMQCACert, c.RabbitMQClientCert, c.RabbitMQClientKey)
+		if err != nil {
+			return amqp.Config{}, err
+		}
+		amqpConfig.TLSClientConfig = tlsConfig
+	}
+	return amqpConfig, nil
+}
+
+// rabbitMQVhost is the configured vhost, or the one named after the user when it's not set. Without a user
+// either it's the default vhost.
+func rabbitMQVhost(c config.Config) string {
+	if c.RabbitMQVhost != "" {
+		return c.RabbitMQVhost
+	}
+	if c.RabbitMQUser != "" {
+		return c.RabbitMQUser
+	}
+	return "/"
+}
+
+// rabbitMQTopology builds the queues and exchange the service works with from the config
+func rabbitMQTopology(c config.Config) (messaging.Topology, error) {
+	var err error
+	args := func(setting, value string) amqp.Table {
+		table, parseErr := messaging.ParseArgs(value)
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("%s: %v", setting, parseErr)
+		}
+		return table
+	}
+
+	downstreamArgs := args("RABBITMQ_DOWNSTREAM_QUEUE_ARGS", c.RabbitMQDownstreamQueueArgs)
+	downstream := func(service, queue string) messaging.Downstream {
+		return messaging.Downstream{
+			Service: service,
+			Queue:   messaging.Queue{Name: queue, Durable: c.RabbitMQDownstreamQueueDurable, Args: downstreamArgs},
+		}
+	}
+
+	topology := messaging.Topology{
+		UserQueue: messaging.Queue{
+			Name:    c.RabbitMQUserQueue,
+			Durable: c.RabbitMQUserQueueDurable,
+			Args:    args("RABBITMQ_USER_QUEUE_ARGS", c.RabbitMQUserQueueArgs),
+		},
+		DeletionReplies: messaging.Queue{
+			Name:    c.RabbitMQDeletionReplyQueue,
+			Durable: c.RabbitMQDeletionReplyQueueDurable,
+			Args:    args("RABBITMQ_DELETION_REPLY_QUEUE_ARGS", c.RabbitMQDeletionReplyQueueArgs),
+		},
+		Events: messaging.Exchange{
+			Name:    c.RabbitMQEventsExchange,
+			Kind:    amqp.ExchangeTopic,
+			Durable: c.RabbitMQEventsExchangeDurable,
+			Args:    args("RABBITMQ_EVENTS_EXCHANGE_ARGS", c.RabbitMQEventsExchangeArgs),
+		},
+		// The sections of the user data follow this order
+		Downstream: []messaging.Downstream{
+			downstream("auth", c.RabbitMQAuthQueue),
+			downstream("authz", c.RabbitMQAuthzQueue),
+			downstream("watch_history", c.RabbitMQWatchHistoryQueue),
+		},
+	}
+	return topology, err
+}
+
+// deletionRouting tells the deletion sagas which services to ask and where replies and events go
+func deletionRouting(topology messaging.Topology) deletion.Routing {
+	routing := deletion.Routing{ReplyQueue: topology.DeletionReplies.Name, EventsExchange: topology.Events.Name}
+	for _, downstream := range topology.Downstream {
+		routing.Participants = append(routing.Participants, deletion.Participant{Service: downstream.Service, Queue: downstream.Queue.Name})
+	}
+	return routing
+}
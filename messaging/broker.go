@@ -0,0 +1,90 @@
+package messaging
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Broker is what the service needs from a message broker: publishing, consuming queues and request/reply.
+// RabbitMQBroker talks to RabbitMQ, MemoryBroker keeps everything in process so the flows can run without one.
+// Both follow RabbitMQ's semantics, messages are routed through exchanges to queues and stay on their queue
+// until a consumer acknowledged them.
+type Broker interface {
+	// Publish sends the message to the exchange with the given routing key, the default exchange "" routes it to
+	// the queue named by the routing key. It returns once the broker took responsibility for the message; a
+	// mandatory message that can't be routed to any queue fails with ErrUnroutable instead of being dropped.
+	Publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error
+	// DeclareQueue makes sure the queue exists
+	DeclareQueue(ctx context.Context, queue Queue) error
+	// DeclareExchange makes sure the exchange exists
+	DeclareExchange(ctx context.Context, exchange Exchange) error
+	// BindQueue routes the messages published to the exchange with a matching routing key to the queue
+	BindQueue(ctx context.Context, queueName, routingKey, exchange string) error
+
+	// Consume passes every message arriving on the queue to the callback, see ConsumeMessage
+	Consume(ctx context.Context, queue Queue, opts ConsumerOptions, callback func(amqp.Delivery) error) *Consumer
+	// ConsumeReplies passes the replies arriving on the queue to the callback, see ConsumeReplies
+	ConsumeReplies(ctx context.Context, queue Queue, callback func(correlationID string, body []byte, failure string) error) *Consumer
+	// RequestAll sends a request to every queue and waits for their replies, see Publisher.RequestAll
+	RequestAll(ctx context.Context, messageType string, data interface{}, queues ...Queue) ([]Reply, error)
+
+	// Close stops accepting publishes, waits until the publishes in flight are done and disconnects
+	Close(ctx context.Context) error
+}
+
+var _ Broker = (*RabbitMQBroker)(nil)
+
+// RabbitMQBroker is the broker on top of a RabbitMQ connection manager, it publishes through a shared publisher
+type RabbitMQBroker struct {
+	manager   *ConnectionManager
+	publisher *Publisher
+}
+
+// NewRabbitMQBroker creates a broker on the manager's connection with a publisher using the given options
+func NewRabbitMQBroker(manager *ConnectionManager, options PublisherOptions) *RabbitMQBroker {
+	return &RabbitMQBroker{manager: manager, publisher: NewPublisher(manager, options)}
+}
+
+// Publish sends the message through the shared publisher and waits for the broker to confirm it
+func (b *RabbitMQBroker) Publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	return b.publisher.Publish(ctx, exchange, routingKey, mandatory, msg)
+}
+
+// DeclareQueue declares the queue once per connection
+func (b *RabbitMQBroker) DeclareQueue(ctx context.Context, queue Queue) error {
+	return b.publisher.DeclareQueue(ctx, queue)
+}
+
+// DeclareExchange declares the exchange once per connection
+func (b *RabbitMQBroker) DeclareExchange(ctx context.Context, exchange Exchange) error {
+	return b.publisher.DeclareExchange(ctx, exchange)
+}
+
+// BindQueue binds the queue to the exchange once per connection
+func (b *RabbitMQBroker) BindQueue(ctx context.Context, queueName, routingKey, exchange string) error {
+	return b.publisher.BindQueue(ctx, queueName, routingKey, exchange)
+}
+
+// Consume consumes the queue with ConsumeMessage, retries and dead letters go through the shared publisher
+func (b *RabbitMQBroker) Consume(ctx context.Context, queue Queue, opts ConsumerOptions, callback func(amqp.Delivery) error) *Consumer {
+	return ConsumeMessage(ctx, b.manager, b.publisher, queue, opts, callback)
+}
+
+// ConsumeReplies consumes the reply queue with ConsumeReplies
+func (b *RabbitMQBroker) ConsumeReplies(ctx context.Context, queue Queue, callback func(correlationID string, body []byte, failure string) error) *Consumer {
+	return ConsumeReplies(ctx, b.manager, queue, callback)
+}
+
+// RequestAll sends the requests through the shared publisher, the replies come back over direct reply-to
+func (b *RabbitMQBroker) RequestAll(ctx context.Context, messageType string, data interface{}, queues ...Queue) ([]Reply, error) {
+	return b.publisher.RequestAll(ctx, messageType, data, queues...)
+}
+
+// Close waits for the broker to confirm the publishes in flight, then closes the connection. The connection is
+// closed even when ctx is done first.
+func (b *RabbitMQBroker) Close(ctx context.Context) error {
+	err := b.publisher.Close(ctx)
+	b.manager.Close()
+	return err
+}
@@ -0,0 +1,397 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Reconnects are attempted with exponential backoff between reconnectBaseDelay and reconnectMaxDelay
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+)
+
+// ErrNotConnected is returned when there's currently no connection to RabbitMQ
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+// ErrManagerClosed is returned once the connection manager has been closed
+var ErrManagerClosed = errors.New("connection manager closed")
+
+// ConnectionState describes the connection to RabbitMQ
+type ConnectionState int
+
+const (
+	// StateConnecting means there's no connection yet, or it was lost and the manager is reconnecting
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateClosed
+)
+
+// String returns the name of the state as used in logs
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// TopologyFunc declares exchanges, queues and bindings on the channel
+type TopologyFunc func(ch *amqp.Channel) error
+
+// ConsumerFunc starts consuming on the channel and handles the deliveries until the channel is closed or ctx is
+// cancelled. On cancellation it stops consuming and returns once the deliveries it's handling are settled.
+type ConsumerFunc func(ctx context.Context, ch *amqp.Channel) error
+
+// ConnectionManager owns a long-lived connection to RabbitMQ. When the connection is lost it reconnects with
+// exponential backoff and jitter, declares the registered topology again and resubscribes the consumers.
+// It never panics, callers find out about problems through errors and the connection state.
+type ConnectionManager struct {
+	url string
+	// config holds the TLS settings and heartbeat of the connection
+	config amqp.Config
+
+	mu    sync.Mutex
+	conn  *amqp.Connection
+	state ConnectionState
+	// lastErr is why the manager isn't connected
+	lastErr error
+	// connected is closed once there's a connection, a new one is made when the connection is lost
+	connected chan struct{}
+	topology  []TopologyFunc
+	listeners []func(ConnectionState, error)
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConnectionManager creates a manager for the broker at the given URL, call Start to connect
+func NewConnectionManager(rabbitmqUrl string, config amqp.Config) *ConnectionManager {
+	return &ConnectionManager{
+		url:       rabbitmqUrl,
+		config:    config,
+		state:     StateConnecting,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start connects in the background and keeps the connection alive until Close is called
+func (m *ConnectionManager) Start() {
+	go m.run()
+}
+
+// DeclareTopology registers topology that is declared on every (re)connect, and declares it right away when connected
+func (m *ConnectionManager) DeclareTopology(fn TopologyFunc) error {
+	m.mu.Lock()
+	m.topology = append(m.topology, fn)
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return declare(conn, []TopologyFunc{fn})
+}
+
+// Consumer is a consumer started by AddConsumer
+type Consumer struct {
+	name string
+	done chan struct{}
+}
+
+// Done is closed once the consumer stopped for good and its channel is closed
+func (c *Consumer) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait blocks until the consumer stopped, or returns an error when ctx is done first
+func (c *Consumer) Wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("consumer %s didn't stop in time: %w", c.name, ctx.Err())
+	}
+}
+
+// AddConsumer runs the consumer on its own channel until ctx is cancelled or the manager is closed. Whenever the
+// channel or connection is lost the consumer is started again once the manager has reconnected.
+func (m *ConnectionManager) AddConsumer(ctx context.Context, name string, fn ConsumerFunc) *Consumer {
+	consumer := &Consumer{name: name, done: make(chan struct{})}
+	go func() {
+		defer close(consumer.done)
+		failures := 0
+		for {
+			ch, err := m.waitForChannel(ctx)
+			if errors.Is(err, ErrManagerClosed) || ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = fn(ctx, ch)
+				ch.Close()
+			}
+			if m.isClosed() || ctx.Err() != nil {
+				return
+			}
+
+			// A consumer that keeps failing on a healthy connection, e.g. because its queue can't be declared,
+			// backs off instead of spinning
+			if err != nil {
+				failures++
+				log.Printf("Consumer %s stopped: %v", name, err)
+			} else {
+				failures = 0
+			}
+			if !m.sleepContext(ctx, backoff(failures)) {
+				return
+			}
+		}
+	}()
+	return consumer
+}
+
+// Channel opens a channel on the current connection
+func (m *ConnectionManager) Channel() (*amqp.Channel, error) {
+	m.mu.Lock()
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return conn.Channel()
+}
+
+// WaitForConnection blocks until the manager is connected, the context is done or the manager is closed
+func (m *ConnectionManager) WaitForConnection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		m.mu.Lock()
+		conn, connected := m.conn, m.connected
+		m.mu.Unlock()
+		if conn != nil && !conn.IsClosed() {
+			return conn, nil
+		}
+		if conn != nil {
+			// The connection was just lost and run hasn't noticed yet, give it a moment
+			connected = nil
+		}
+
+		select {
+		case <-connected:
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-m.done:
+			return nil, ErrManagerClosed
+		}
+	}
+}
+
+// State returns the current state of the connection and, when not connected, the last error
+func (m *ConnectionManager) State() (ConnectionState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.lastErr
+}
+
+// OnStateChange registers a function that is called whenever the state of the connection changes
+func (m *ConnectionManager) OnStateChange(fn func(ConnectionState, error)) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, fn)
+	state, err := m.state, m.lastErr
+	m.mu.Unlock()
+
+	// Let the listener know where things stand
+	fn(state, err)
+}
+
+// Close stops reconnecting and closes the connection, consumers stop along with it
+func (m *ConnectionManager) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		conn := m.conn
+		m.conn = nil
+		m.mu.Unlock()
+
+		if conn != nil && !conn.IsClosed() {
+			err = conn.Close()
+		}
+		m.setState(StateClosed, nil)
+	})
+	return err
+}
+
+// run connects, waits for the connection to be lost and connects again, until the manager is closed
+func (m *ConnectionManager) run() {
+	attempt := 0
+	for {
+		// Close makes both the lost connection and done ready, so don't rely on select picking done
+		if m.isClosed() {
+			return
+		}
+		conn, err := m.dial()
+		if err != nil {
+			attempt++
+			delay := backoff(attempt)
+			log.Printf("Connecting to RabbitMQ failed (attempt %d), retrying in %s: %v", attempt, delay.Round(time.Millisecond), err)
+			m.setState(StateConnecting, err)
+			if !m.sleep(delay) {
+				return
+			}
+			continue
+		}
+		attempt = 0
+
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		m.mu.Lock()
+		// Close may have run while dialing and found no connection to close. Checking under the lock means
+		// that either Close sees the new connection or the connection is closed here.
+		if m.isClosed() {
+			m.mu.Unlock()
+			conn.Close()
+			return
+		}
+		m.conn = conn
+		close(m.connected)
+		m.mu.Unlock()
+		m.setState(StateConnected, nil)
+		log.Println("Connected to RabbitMQ")
+
+		select {
+		case reason := <-closed:
+			err := fmt.Errorf("connection lost: %v", reason)
+			log.Printf("RabbitMQ %v, reconnecting", err)
+			m.mu.Lock()
+			m.conn = nil
+			m.connected = make(chan struct{})
+			m.mu.Unlock()
+			m.setState(StateConnecting, err)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// dial opens a connection and declares the registered topology on it
+func (m *ConnectionManager) dial() (*amqp.Connection, error) {
+	conn, err := ConnectToRabbitMQ(m.url, m.config)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	topology := append([]TopologyFunc(nil), m.topology...)
+	m.mu.Unlock()
+	if err := declare(conn, topology); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// waitForChannel opens a channel as soon as there's a connection
+func (m *ConnectionManager) waitForChannel(ctx context.Context) (*amqp.Channel, error) {
+	conn, err := m.WaitForConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Channel()
+}
+
+func (m *ConnectionManager) setState(state ConnectionState, err error) {
+	m.mu.Lock()
+	if m.state == StateClosed {
+		m.mu.Unlock()
+		return
+	}
+	changed := m.state != state
+	m.state, m.lastErr = state, err
+	listeners := append([]func(ConnectionState, error){}, m.listeners...)
+	m.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, listener := range listeners {
+		listener(state, err)
+	}
+}
+
+func (m *ConnectionManager) isClosed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the given duration, it returns false when the manager was closed in the meantime
+func (m *ConnectionManager) sleep(d time.Duration) bool {
+	return m.sleepContext(context.Background(), d)
+}
+
+// sleepContext waits for the given duration, it returns false when the manager was closed or ctx was done
+// in the meantime
+func (m *ConnectionManager) sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-m.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// declare runs the topology functions on a temporary channel
+func declare(conn *amqp.Connection, topology []TopologyFunc) error {
+	if len(topology) == 0 {
+		return nil
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+
+	for _, fn := range topology {
+		if err := fn(ch); err != nil {
+			return fmt.Errorf("failed to declare topology: %v", err)
+		}
+	}
+	return nil
+}
+
+// backoff returns the delay before the given attempt, growing exponentially with "equal jitter" so that
+// many instances losing the broker at once don't all reconnect at the same moment
+func backoff(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	delay := float64(reconnectBaseDelay) * math.Pow(2, float64(attempt-1))
+	if delay > float64(reconnectMaxDelay) {
+		delay = float64(reconnectMaxDelay)
+	}
+	half := delay / 2
+	return time.Duration(half + rand.Float64()*half)
+}
@@ -1,52 +1,282 @@
 package messaging
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func ConsumeMessage(conn *amqp.Connection, queueName string, callback func([]byte) error) {
-	ch, err := conn.Channel()
-	FailOnError(err, "Failed to open a channel")
-	defer ch.Close()
+// ConsumerOptions tune how the messages of a queue are consumed
+type ConsumerOptions struct {
+	Retry RetryPolicy
+	// Prefetch is the number of unacknowledged messages the broker hands out at once
+	Prefetch int
+	// Workers is the number of messages handled concurrently
+	Workers int
+	// OrderingKey returns the key of a message, messages with the same key are handled one after the other in
+	// the order they arrived. Messages with an empty key, or all messages when it's nil, can go to any worker.
+	// The order is best-effort: a message that is retried comes back after the later messages of its key.
+	OrderingKey func(amqp.Delivery) string
+}
 
-	q, err := ch.QueueDeclare(
-		queueName, // name
-		false,     // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-	FailOnError(err, "Failed to declare a queue")
+// withDefaults returns the options with the values that are too low raised to their minimum
+func (opts ConsumerOptions) withDefaults() ConsumerOptions {
+	if opts.Retry.MaxAttempts < 1 {
+		opts.Retry.MaxAttempts = 1
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Prefetch < opts.Workers {
+		// Fewer messages in flight than workers would leave workers idle
+		opts.Prefetch = opts.Workers
+	}
+	return opts
+}
+
+// ConsumeMessage passes every message arriving on the queue to the callback. The consumer is registered with the
+// connection manager, so it's resubscribed whenever the connection was lost.
+//
+// Messages are handled by a fixed number of workers, every ordering key always goes to the same worker. That
+// keeps the messages of a key in order as long as they succeed; retries aren't ordered, see below. When the
+// workers fall behind, for instance because MongoDB is slow, no more messages are taken in: the broker stops
+// delivering once the prefetch limit of unacknowledged messages is reached.
+//
+// Messages are acknowledged once the callback succeeded. A message the callback failed on is moved to a retry
+// queue and handled again after a delay, until the retry policy is exhausted or the error is permanent; then
+// it's dead-lettered. Messages of the same key that arrive in the meantime don't wait for the retry. Retried and dead-lettered messages are published through the publisher, and the original
+// is only acknowledged once the broker confirmed the copy.
+//
+// Once ctx is cancelled no more messages are taken in. The messages the workers are handling are finished and
+// settled first, the consumer is done after that.
+func ConsumeMessage(ctx context.Context, m *ConnectionManager, publisher *Publisher, queue Queue, opts ConsumerOptions, callback func(amqp.Delivery) error) *Consumer {
+	opts = opts.withDefaults()
+	policy := opts.Retry
+	queueName := queue.Name
+	if err := m.DeclareTopology(retryTopology(queueName, policy)); err != nil {
+		log.Printf("Failed to declare retry queues of %s: %v", queueName, err)
+	}
+
+	return m.AddConsumer(ctx, queueName, func(ctx context.Context, ch *amqp.Channel) error {
+		q, err := ch.QueueDeclare(
+			queueName,     // name
+			queue.Durable, // durable
+			false,         // delete when unused
+			false,         // exclusive
+			false,         // no-wait
+			queue.Args,    // arguments
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare a queue: %v", err)
+		}
+
+		if err := ch.Qos(
+			opts.Prefetch, // prefetch count
+			0,             // prefetch size
+			false,         // global
+		); err != nil {
+			return fmt.Errorf("failed to set the prefetch count: %v", err)
+		}
+
+		msgs, err := consume(ctx, ch, q.Name)
+		if err != nil {
+			return err
+		}
+
+		log.Printf(" [*] Waiting for messages on %s with %d worker(s)", q.Name, opts.Workers)
+		dispatch(msgs, opts.Workers, opts.OrderingKey, func(d amqp.Delivery) {
+			log.Printf("Received a message: %s", d.Body)
+			settle(d, handleDelivery(publisher.Publish, queueName, policy, d, callback))
+		})
+		return nil
+	})
+}
 
+// consume starts consuming from the queue on the channel and cancels the subscription once ctx is done. The
+// deliveries channel is closed after the cancellation; deliveries that weren't handled by then are requeued
+// when the channel is closed.
+func consume(ctx context.Context, ch *amqp.Channel, queueName string) (<-chan amqp.Delivery, error) {
+	tag := queueName + "." + primitive.NewObjectID().Hex()
 	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
+		queueName, // queue
+		tag,       // consumer
+		false,     // auto-ack
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
 	)
-	FailOnError(err, "Failed to register a consumer")
-
-	var forever chan struct{}
+	if err != nil {
+		return nil, fmt.Errorf("failed to register a consumer: %v", err)
+	}
 
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
 	go func() {
-		for d := range msgs {
-			log.Printf("Received a message: %s", d.Body)
-			callback(d.Body)
+		select {
+		case <-ctx.Done():
+			if err := ch.Cancel(tag, false); err != nil {
+				log.Printf("Failed to cancel consumer %s: %v", tag, err)
+			}
+		case <-closed:
 		}
 	}()
+	return msgs, nil
+}
 
-	log.Printf(" [*] Waiting for messages. To exit press CTRL+C")
-	<-forever
+// dispatch hands the deliveries to a pool of workers until the deliveries channel is closed, which happens along
+// with the channel or connection, and returns once the workers are done. Every worker has a queue of one message,
+// so a busy worker holds up the dispatching instead of messages piling up in memory.
+func dispatch(msgs <-chan amqp.Delivery, workers int, orderingKey func(amqp.Delivery) string, handle func(amqp.Delivery)) {
+	queues := make([]chan amqp.Delivery, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery, 1)
+		wg.Add(1)
+		go func(queue <-chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range queue {
+				handle(d)
+			}
+		}(queues[i])
+	}
+
+	next := 0
+	for d := range msgs {
+		var key string
+		if orderingKey != nil {
+			key = orderingKey(d)
+		}
+		worker := next
+		if key != "" {
+			hash := fnv.New32a()
+			hash.Write([]byte(key))
+			worker = int(hash.Sum32() % uint32(workers))
+		} else {
+			next = (next + 1) % workers
+		}
+		queues[worker] <- d
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
 }
 
-func FailOnError(err error, msg string) {
+// publishFunc publishes a message, like Publisher.Publish and Broker.Publish
+type publishFunc func(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error
+
+// handleDelivery runs the callback on the delivery and takes care of a failure. It returns an error when the
+// failure couldn't be taken care of, the delivery should then be redelivered.
+func handleDelivery(publish publishFunc, queueName string, policy RetryPolicy, d amqp.Delivery, callback func(amqp.Delivery) error) error {
+	err := callback(d)
+	if err == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	attempt := attemptOf(d)
+	if IsPermanent(err) || attempt >= policy.MaxAttempts {
+		log.Printf("Dead-lettering message from %s after %d attempt(s): %v", queueName, attempt, err)
+		return publish(ctx, DeadLetterExchange(queueName), queueName, true, republishing(d, amqp.Table{
+			AttemptHeader:       int32(attempt),
+			LastErrorHeader:     err.Error(),
+			OriginalQueueHeader: queueName,
+			FailedAtHeader:      time.Now().UTC().Format(time.RFC3339),
+		}))
+	}
+
+	log.Printf("Retrying message from %s in %s (attempt %d failed): %v", queueName, policy.delay(attempt), attempt, err)
+	return publish(ctx, "", RetryQueue(queueName, attempt), true, republishing(d, amqp.Table{
+		AttemptHeader:       int32(attempt + 1),
+		LastErrorHeader:     err.Error(),
+		OriginalQueueHeader: queueName,
+	}))
+}
+
+// settle acknowledges the delivery, or requeues it when handling it failed
+func settle(d amqp.Delivery, err error) {
 	if err != nil {
-		log.Panicf("%s: %s", msg, err)
+		log.Printf("Failed to handle message, requeueing it: %v", err)
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Failed to requeue message: %v", err)
+		}
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to acknowledge message: %v", err)
+	}
+}
+
+// ConsumeReplies consumes the replies arriving on the queue and passes their correlation id, data and the
+// failure reported in the error header, if any. A reply is acknowledged once the callback handled it and
+// requeued when it failed, so no reply is lost as long as the queue is durable. The consumer stops once ctx
+// is cancelled.
+//
+// Replies are handled one at a time. After a failure the consumer backs off before requeueing, longer with every
+// reply that fails in a row, so a callback that keeps failing, e.g. while MongoDB is down, doesn't spin on the
+// same reply.
+func ConsumeReplies(ctx context.Context, m *ConnectionManager, queue Queue, callback func(correlationID string, body []byte, failure string) error) *Consumer {
+	replies := &replyHandler{callback: callback}
+	return m.AddConsumer(ctx, queue.Name, func(ctx context.Context, ch *amqp.Channel) error {
+		q, err := ch.QueueDeclare(
+			queue.Name,    // name
+			queue.Durable, // durable
+			false,         // delete when unused
+			false,         // exclusive
+			false,         // no-wait
+			queue.Args,    // arguments
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare a queue: %v", err)
+		}
+
+		msgs, err := consume(ctx, ch, q.Name)
+		if err != nil {
+			return err
+		}
+
+		for d := range msgs {
+			replies.handle(ctx, d)
+		}
+		return nil
+	})
+}
+
+// replyHandler passes replies to the callback and counts the replies that failed in a row
+type replyHandler struct {
+	callback func(correlationID string, body []byte, failure string) error
+	failures int
+}
+
+// handle passes the reply to the callback, it's acknowledged when the callback succeeded and requeued after a
+// backoff otherwise. The backoff is cut short once ctx is done.
+func (h *replyHandler) handle(ctx context.Context, d amqp.Delivery) {
+	var failure string
+	if reason, ok := d.Headers[ReplyErrorHeader]; ok {
+		failure = fmt.Sprintf("%v", reason)
+	}
+	if err := h.callback(d.CorrelationId, replyData(d.Body), failure); err != nil {
+		h.failures++
+		delay := backoff(h.failures)
+		log.Printf("Failed to handle reply %s, requeueing it in %s: %v", d.CorrelationId, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		d.Nack(false, true)
+		return
 	}
+	h.failures = 0
+	d.Ack(false)
 }
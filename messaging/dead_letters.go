@@ -0,0 +1,87 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter is a message fetched from a dead-letter queue. It stays on the queue until it's acknowledged by
+// Replay or Discard, or requeued by Keep, closing the channel it was fetched on requeues it as well.
+type DeadLetter struct {
+	// Position is the place of the message in the queue at the time it was fetched, starting at 1
+	Position      int
+	MessageID     string
+	Attempts      int
+	LastError     string
+	OriginalQueue string
+	FailedAt      string
+	Headers       amqp.Table
+	Body          []byte
+
+	delivery amqp.Delivery
+}
+
+// FetchDeadLetters takes up to limit messages off the dead-letter queue of queueName without acknowledging them
+func FetchDeadLetters(ch *amqp.Channel, queueName string, limit int) ([]*DeadLetter, error) {
+	var letters []*DeadLetter
+	for limit <= 0 || len(letters) < limit {
+		d, ok, err := ch.Get(DeadLetterQueue(queueName), false)
+		if err != nil {
+			return letters, fmt.Errorf("failed to fetch a message: %v", err)
+		}
+		if !ok {
+			break
+		}
+
+		letter := &DeadLetter{
+			Position:      len(letters) + 1,
+			MessageID:     d.MessageId,
+			Attempts:      attemptOf(d),
+			OriginalQueue: queueName,
+			Headers:       d.Headers,
+			Body:          d.Body,
+			delivery:      d,
+		}
+		if reason, ok := d.Headers[LastErrorHeader].(string); ok {
+			letter.LastError = reason
+		}
+		if original, ok := d.Headers[OriginalQueueHeader].(string); ok && original != "" {
+			letter.OriginalQueue = original
+		}
+		if failedAt, ok := d.Headers[FailedAtHeader].(string); ok {
+			letter.FailedAt = failedAt
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// Replay publishes the message back onto its original queue, declared as the topology says, with a fresh attempt
+// count and removes it from the dead-letter queue once the broker confirmed the copy
+func (p *Publisher) Replay(ctx context.Context, letter *DeadLetter, topology Topology) error {
+	if err := p.DeclareQueue(ctx, topology.Queue(letter.OriginalQueue)); err != nil {
+		return err
+	}
+
+	msg := republishing(letter.delivery, amqp.Table{"x-replayed-at": time.Now().UTC().Format(time.RFC3339)})
+	for _, header := range []string{AttemptHeader, LastErrorHeader, FailedAtHeader} {
+		delete(msg.Headers, header)
+	}
+	if err := p.Publish(ctx, "", letter.OriginalQueue, true, msg); err != nil {
+		return err
+	}
+	return letter.delivery.Ack(false)
+}
+
+// Discard removes the message from the dead-letter queue
+func (l *DeadLetter) Discard() error {
+	return l.delivery.Ack(false)
+}
+
+// Keep puts the message back on the dead-letter queue
+func (l *DeadLetter) Keep() error {
+	return l.delivery.Nack(false, true)
+}
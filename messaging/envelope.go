@@ -0,0 +1,150 @@
+package messaging
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Message types of the commands accepted on the user queue
+const (
+	TypeSaveRecord    = "saveRecord"
+	TypeUpdateRecord  = "updateRecord"
+	TypeDeleteRecord  = "deleteRecord"
+	TypeGetAllRecords = "getAllRecords"
+)
+
+// TypeGetAllRecordsReply is the type of the reply to a getAllRecords request
+const TypeGetAllRecordsReply = "getAllRecords.reply"
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schemaURL is where the embedded schemas are registered with the compiler, validation errors refer to it
+const schemaURL = "mem:///schemas/"
+
+// envelopeSchema validates the envelope of a message, dataSchemas the data of every accepted message type
+var envelopeSchema, dataSchemas = compileSchemas()
+
+// compileSchemas loads the embedded JSON schemas, they're part of the binary so a broken schema is a bug
+func compileSchemas() (*jsonschema.Schema, map[string]*jsonschema.Schema) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.AssertFormat = true
+	for _, name := range []string{"envelope.json", "user_queue.json"} {
+		data, err := schemaFiles.ReadFile("schemas/" + name)
+		if err != nil {
+			panic(fmt.Sprintf("messaging: failed to read schema %s: %v", name, err))
+		}
+		if err := compiler.AddResource(schemaURL+name, bytes.NewReader(data)); err != nil {
+			panic(fmt.Sprintf("messaging: failed to load schema %s: %v", name, err))
+		}
+	}
+
+	envelope := compiler.MustCompile(schemaURL + "envelope.json")
+	data := map[string]*jsonschema.Schema{}
+	for _, messageType := range []string{TypeSaveRecord, TypeUpdateRecord, TypeDeleteRecord, TypeGetAllRecords} {
+		data[messageType] = compiler.MustCompile(schemaURL + "user_queue.json#/$defs/" + messageType)
+	}
+	return envelope, data
+}
+
+// peekUserID reads the user id of a message from the user queue, from the data of an envelope or from the flat
+// format, without validating the message. It returns an empty string when there's none.
+func peekUserID(body []byte) string {
+	var probe struct {
+		SpecVersion *string `json:"specversion"`
+		UserID      string  `json:"user_id"`
+		Data        struct {
+			UserID string `json:"user_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return ""
+	}
+	if probe.SpecVersion != nil {
+		return probe.Data.UserID
+	}
+	return probe.UserID
+}
+
+// decodeMessage parses a message from the user queue. Besides envelopes it accepts the flat format used before,
+// where the user fields and the action share one object. The data is validated against the schema of its
+// type, a message that doesn't match is rejected permanently since it won't get any better.
+func decodeMessage(body []byte) (*Message, error) {
+	// Numbers are kept as json.Number so the schema sees integers as they were sent
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, Permanent(fmt.Errorf("malformed JSON: %v", err))
+	}
+	fields, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, Permanent(errors.New("message isn't a JSON object"))
+	}
+
+	var messageType string
+	var data interface{}
+	var dataJSON []byte
+	if _, ok := fields["specversion"]; ok {
+		if err := envelopeSchema.Validate(doc); err != nil {
+			return nil, Permanent(fmt.Errorf("invalid envelope: %v", err))
+		}
+		var envelope events.Envelope
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return nil, Permanent(fmt.Errorf("invalid envelope: %v", err))
+		}
+		messageType, data, dataJSON = envelope.Type, fields["data"], envelope.Data
+	} else {
+		// The flat format names its type in the action field
+		messageType, _ = fields["action"].(string)
+		data, dataJSON = doc, body
+	}
+
+	schema, ok := dataSchemas[messageType]
+	if !ok {
+		return nil, Permanent(fmt.Errorf("unknown action %q", messageType))
+	}
+	if err := schema.Validate(data); err != nil {
+		return nil, Permanent(fmt.Errorf("invalid %s message: %v", messageType, err))
+	}
+
+	var msg Message
+	if err := json.Unmarshal(dataJSON, &msg); err != nil {
+		return nil, Permanent(fmt.Errorf("invalid %s message: %v", messageType, err))
+	}
+	msg.Action = messageType
+	return &msg, nil
+}
+
+// envelopePublishing builds the persistent message carrying the envelope
+func envelopePublishing(envelope *events.Envelope) (amqp.Publishing, error) {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return amqp.Publishing{}, fmt.Errorf("failed to marshal %s envelope: %v", envelope.Type, err)
+	}
+	return amqp.Publishing{
+		ContentType:  events.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    envelope.ID,
+		Type:         envelope.Type,
+		Timestamp:    envelope.Time,
+		Body:         body,
+	}, nil
+}
+
+// replyData returns the data of a reply, replies from services that don't use envelopes yet are returned as is
+func replyData(body []byte) []byte {
+	var envelope events.Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.SpecVersion == "" {
+		return body
+	}
+	return envelope.Data
+}
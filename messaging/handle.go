@@ -1,11 +1,18 @@
 package messaging
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
-	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/globals"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/service"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -21,22 +28,62 @@ type Message struct {
 	ExpirationDate   string `json:"expiration_date"`
 	CVC              int32  `json:"cvc"`
 	Action           string `json:"action"`
+	// Fields lists the fields an updateRecord changes, without it the fields set in the message are changed
+	Fields []string `json:"fields,omitempty"`
+	// ExpectedVersion makes an updateRecord fail when the user was changed in the meantime
+	ExpectedVersion int64 `json:"expected_version,omitempty"`
 }
 
-func HandleMessage(body []byte) error {
-	jsonStr := string(body)
-	var msg Message
-	err := json.Unmarshal([]byte(jsonStr), &msg)
+// user converts the fields of the message into a user
+func (m *Message) user() *models.User {
+	return &models.User{
+		UserID:           m.UserId,
+		Email:            m.Email,
+		Phone:            m.Phone,
+		DateOfBirth:      m.DateOfBirth,
+		FirstName:        m.FirstName,
+		LastName:         m.LastName,
+		CreditCardNumber: m.CreditCardNumber,
+		ExpirationDate:   m.ExpirationDate,
+		CVC:              m.CVC,
+	}
+}
+
+// MessageHandler handles the messages arriving on the user queue
+type MessageHandler struct {
+	service *service.UserService
+	broker  Broker
+	// actor is recorded in the audit trail for changes arriving through the user queue, e.g. "queue:user_queue"
+	actor string
+}
+
+// NewMessageHandler creates a handler for the messages of the named queue that changes users through the
+// service and sends replies through the broker
+func NewMessageHandler(userService *service.UserService, broker Broker, queueName string) *MessageHandler {
+	return &MessageHandler{service: userService, broker: broker, actor: "queue:" + queueName}
+}
+
+// HandleMessage handles a message from the user queue, it's either an envelope or in the flat format
+func (h *MessageHandler) HandleMessage(d amqp.Delivery) error {
+	msg, err := decodeMessage(d.Body)
 	if err != nil {
-		log.Println("Failed to unmarshal JSON:", err)
+		log.Println("Rejecting message:", err)
 		return err
 	}
 
+	ctx := context.Background()
+	origin := service.Origin{Actor: h.actor, Action: msg.Action, Source: audit.SourceAMQP}
+
 	switch msg.Action {
-	case "saveRecord":
-		// Insert the data into the database, result contains the newly generated Object ID for the new document
-		_, err := globals.UserDb.InsertOne(globals.MongoCtx, msg)
+	case TypeSaveRecord:
+		// Store the user along with its audit entry and user.created event, the repository generates a new
+		// Object ID for the document
+		user := msg.user()
+		err := h.service.Create(ctx, origin, user)
 		// check for potential errors
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			return Permanent(status.Errorf(codes.AlreadyExists, "A user with email %s already exists", user.Email))
+		}
 		if err != nil {
 			// return internal gRPC error to be handled later
 			return status.Errorf(
@@ -44,9 +91,86 @@ func HandleMessage(body []byte) error {
 				fmt.Sprintf("Internal error: %v", err),
 			)
 		}
+	case TypeUpdateRecord:
+		changes := msg.user()
+		_, err := h.service.Update(ctx, origin, service.Ref{UserID: msg.UserId}, msg.ExpectedVersion, changes, msg.Fields)
+		var fieldErr *service.FieldError
+		if errors.As(err, &fieldErr) {
+			return Permanent(status.Errorf(codes.InvalidArgument, "Invalid fields: %v", err))
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			return Permanent(status.Errorf(codes.NotFound, "Could not find user with id %s", msg.UserId))
+		}
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			return Permanent(status.Errorf(codes.AlreadyExists, "A user with email %s already exists", models.NormalizeEmail(changes.Email)))
+		}
+		// Without an expected version a conflict only means another write got in between, the retry reads
+		// the user again
+		if errors.Is(err, repository.ErrVersionConflict) && msg.ExpectedVersion != 0 {
+			return Permanent(status.Errorf(codes.Aborted, "User %s was modified concurrently, expected version %d is no longer current", msg.UserId, msg.ExpectedVersion))
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "Internal error: %v", err)
+		}
+	case TypeDeleteRecord:
+		// The user is only marked as deleted, the purger erases it once the grace period has passed
+		_, err := h.service.Delete(ctx, origin, msg.UserId)
+		if errors.Is(err, repository.ErrNotFound) {
+			return Permanent(status.Errorf(codes.NotFound, "No user(s) with id %s found", msg.UserId))
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "Could not delete user(s) with id %s: %v", msg.UserId, err)
+		}
+	case TypeGetAllRecords:
+		return h.replyWithUser(ctx, d, msg.UserId)
 	default:
-		fmt.Println("Unknown action:", msg.Action)
+		// decodeMessage only lets through the types above, keep anything else in the dead-letter queue for inspection
+		return Permanent(fmt.Errorf("unknown action %q", msg.Action))
 	}
 
 	return nil
 }
+
+// replyWithUser answers a getAllRecords request on the caller's reply-to queue. Like the other services do,
+// an unknown user is reported in the error header of the reply.
+func (h *MessageHandler) replyWithUser(ctx context.Context, d amqp.Delivery, userID string) error {
+	if d.ReplyTo == "" {
+		return Permanent(errors.New("getAllRecords requires a reply_to queue"))
+	}
+
+	// Users pending deletion still have their data, so they are included. The reply carries the user in the
+	// same shape other services get it in, or no data when it failed.
+	var data interface{}
+	var headers amqp.Table
+	user, err := h.service.Get(ctx, service.Ref{UserID: userID}, repository.IncludeDeleted)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		headers = amqp.Table{ReplyErrorHeader: fmt.Sprintf("no user with id %s found", userID)}
+	case err != nil:
+		return status.Errorf(codes.Internal, "Internal error: %v", err)
+	default:
+		data = user
+	}
+	envelope, err := events.NewEnvelope(TypeGetAllRecordsReply, data)
+	if err != nil {
+		return Permanent(err)
+	}
+	reply, err := envelopePublishing(envelope)
+	if err != nil {
+		return Permanent(err)
+	}
+	reply.Headers = headers
+	reply.CorrelationId = d.CorrelationId
+
+	// The reply isn't mandatory, a caller that stopped waiting has nothing left to route it to
+	publishCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return h.broker.Publish(publishCtx, "", d.ReplyTo, false, reply)
+}
+
+// OrderingKey returns the user a message is about, so messages about the same user are handled in order.
+// It runs for every delivery before it's handled, so it only peeks at the user id; the message is validated
+// once it's handled. Messages without a user id have no key.
+func (h *MessageHandler) OrderingKey(d amqp.Delivery) string {
+	return peekUserID(d.Body)
+}
@@ -0,0 +1,99 @@
+package messaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/idempotency"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// dedupHits counts the redelivered messages that were skipped, per queue. It's published on /debug/vars.
+var dedupHits = expvar.NewMap("messaging_dedup_hits")
+
+// processingLease is how long a message claimed by a consumer is left to it. A redelivery within the lease is
+// retried later, after it the message is taken over, e.g. because the consumer that claimed it crashed.
+const processingLease = 30 * time.Second
+
+// Idempotent wraps a consumer callback so every message is processed only once, even when the broker delivers
+// it again. Messages are recognized by their message id, or by a hash of their body when they have none.
+// A message that was processed before is skipped and acknowledged. Since a new message without an id may carry
+// the same body as an earlier one, e.g. a second getAllRecords for the same user, those are only looked up when
+// the broker redelivered them or they're retried.
+//
+// A message that is looked up is claimed atomically before the callback runs, so two consumers handling copies of
+// it at the same time can't both process it; the one that loses the claim fails, and the message is retried. The
+// claim is rolled back when the callback fails, so failed messages are still retried. A consumer that crashes
+// after the callback succeeded but before the message was recorded as processed still leads to it being
+// processed again once the lease runs out; the store narrows that window, it can't close it.
+func Idempotent(store idempotency.Store, queueName string, callback func(amqp.Delivery) error) func(amqp.Delivery) error {
+	return func(d amqp.Delivery) error {
+		key := messageKey(queueName, d)
+
+		if d.MessageId == "" && !d.Redelivered && attemptOf(d) <= 1 {
+			if err := callback(d); err != nil {
+				return err
+			}
+			markProcessed(store, key)
+			return nil
+		}
+
+		// MongoDB keeps milliseconds, the claim is released by its exact time
+		claimedAt := time.Now().UTC().Truncate(time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result, err := store.Claim(ctx, key, claimedAt, processingLease)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to claim message %s: %v", key, err)
+		}
+		switch result {
+		case idempotency.AlreadyProcessed:
+			log.Printf("Skipping message %s, it was processed before", key)
+			dedupHits.Add(queueName, 1)
+			return nil
+		case idempotency.InProgress:
+			return fmt.Errorf("message %s is being processed by another consumer", key)
+		}
+
+		if err := callback(d); err != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if releaseErr := store.Release(ctx, key, claimedAt); releaseErr != nil {
+				log.Printf("Failed to release the claim on message %s, a retry waits for its lease to run out: %v", key, releaseErr)
+			}
+			return err
+		}
+		markProcessed(store, key)
+		return nil
+	}
+}
+
+// markProcessed records the message as processed. The message was handled, failing to record it only risks
+// handling a redelivery again.
+func markProcessed(store idempotency.Store, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.MarkProcessed(ctx, key, time.Now().UTC()); err != nil {
+		log.Printf("Failed to record message %s as processed: %v", key, err)
+	}
+}
+
+// messageKey identifies a message on the queue by its id. A message without one is identified by the hash of
+// its correlation id, reply queue and body, so requests that expect a reply of their own don't match each other.
+func messageKey(queueName string, d amqp.Delivery) string {
+	if d.MessageId != "" {
+		return queueName + "/id/" + d.MessageId
+	}
+	hash := sha256.New()
+	for _, part := range [][]byte{[]byte(d.CorrelationId), []byte(d.ReplyTo), d.Body} {
+		// Prefix every part with its length so the parts can't run into each other
+		fmt.Fprintf(hash, "%d:", len(part))
+		hash.Write(part)
+	}
+	return queueName + "/sha256/" + hex.EncodeToString(hash.Sum(nil))
+}
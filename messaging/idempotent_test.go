@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/idempotency"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestIdempotentSkipsProcessedMessages(t *testing.T) {
+	var calls int32
+	handle := Idempotent(idempotency.NewMemoryStore(time.Hour), "orders", func(d amqp.Delivery) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	d := amqp.Delivery{MessageId: "message-1", Body: []byte("hello")}
+	for i := 0; i < 2; i++ {
+		if err := handle(d); err != nil {
+			t.Fatalf("Delivery %d: %v", i+1, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("The message was processed %d time(s), want 1", calls)
+	}
+}
+
+func TestIdempotentClaimsBeforeProcessing(t *testing.T) {
+	var calls int32
+	started, finish := make(chan struct{}), make(chan struct{})
+	handle := Idempotent(idempotency.NewMemoryStore(time.Hour), "orders", func(d amqp.Delivery) error {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-finish
+		return nil
+	})
+
+	// A redelivery arrives at another worker while the first copy is still being processed
+	d := amqp.Delivery{MessageId: "message-1"}
+	done := make(chan error, 1)
+	go func() { done <- handle(d) }()
+	receive(t, started)
+
+	redelivered := d
+	redelivered.Redelivered = true
+	if err := handle(redelivered); err == nil {
+		t.Error("The redelivery was accepted while the message was being processed, want it to be retried")
+	}
+	close(finish)
+	if err := receive(t, done); err != nil {
+		t.Fatalf("First delivery: %v", err)
+	}
+	if err := handle(redelivered); err != nil {
+		t.Errorf("Redelivery after processing: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("The message was processed %d time(s), want 1", calls)
+	}
+}
+
+func TestIdempotentReleasesFailedMessages(t *testing.T) {
+	var calls int32
+	handle := Idempotent(idempotency.NewMemoryStore(time.Hour), "orders", func(d amqp.Delivery) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("database down")
+		}
+		return nil
+	})
+
+	d := amqp.Delivery{MessageId: "message-1"}
+	if err := handle(d); err == nil {
+		t.Fatal("The failing delivery succeeded")
+	}
+	d.Redelivered = true
+	if err := handle(d); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("The message was processed %d time(s), want it to be retried once", calls)
+	}
+}
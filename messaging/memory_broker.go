@@ -0,0 +1,653 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// MemoryBroker is a broker that lives in the process, so the flows of the service can run without RabbitMQ,
+// e.g. in tests. It follows RabbitMQ's semantics:
+//   - the default exchange routes a message to the queue named by its routing key, direct, fanout and topic
+//     exchanges route it through their bindings
+//   - a message stays on its queue until a consumer acknowledged it; a message that is requeued, or that was
+//     unacknowledged when its consumer stopped, is delivered again with Redelivered set
+//   - queues honour the x-message-ttl, x-dead-letter-exchange and x-dead-letter-routing-key arguments, so
+//     messages are retried and dead-lettered exactly like on RabbitMQ
+//   - a consumer holds at most its prefetch count of unacknowledged messages
+//
+// Nothing is persisted, the messages are gone once the process stops.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	queues    map[string]*memoryQueue
+	exchanges map[string]*memoryExchange
+	// unacked holds the deliveries waiting to be settled by their delivery tag
+	unacked map[uint64]*memoryDelivery
+	lastTag uint64
+
+	// closed is closed by Close, publishes fail and consumers stop from then on
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// memoryQueue is a queue of a MemoryBroker, its fields are guarded by the broker's mutex
+type memoryQueue struct {
+	name string
+	args amqp.Table
+	// ready holds the messages waiting for a consumer, in order
+	ready []*memoryMessage
+	// outstanding counts the unacknowledged messages of every consumer
+	outstanding map[string]int
+	// changed is closed, and replaced, whenever messages become ready or are settled
+	changed chan struct{}
+}
+
+// memoryExchange is an exchange of a MemoryBroker along with its bindings
+type memoryExchange struct {
+	kind     string
+	bindings []memoryBinding
+}
+
+type memoryBinding struct {
+	queue      string
+	routingKey string
+}
+
+// memoryMessage is a message on a queue along with how it got there
+type memoryMessage struct {
+	exchange    string
+	routingKey  string
+	msg         amqp.Publishing
+	redelivered bool
+}
+
+// memoryDelivery is a message handed to a consumer that hasn't been settled yet
+type memoryDelivery struct {
+	queue       *memoryQueue
+	consumerTag string
+	message     *memoryMessage
+}
+
+// settlement is what happens to a delivery when it's settled
+type settlement int
+
+const (
+	settleAck settlement = iota
+	settleRequeue
+	// settleReject dead-letters the message when the queue has a dead-letter exchange and drops it otherwise
+	settleReject
+)
+
+// NewMemoryBroker creates an empty broker with just the default exchange
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:    make(map[string]*memoryQueue),
+		exchanges: make(map[string]*memoryExchange),
+		unacked:   make(map[uint64]*memoryDelivery),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Publish routes the message to the queues right away, so it returns once they hold it
+func (b *MemoryBroker) Publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.isClosed() {
+		return ErrPublisherClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queues, err := b.route(exchange, routingKey)
+	if err != nil {
+		return err
+	}
+	if len(queues) == 0 {
+		if mandatory {
+			return fmt.Errorf("%w: %s to %q", ErrUnroutable, routingKey, exchange)
+		}
+		return nil
+	}
+	for _, q := range queues {
+		b.enqueue(q, &memoryMessage{exchange: exchange, routingKey: routingKey, msg: copyPublishing(msg)})
+	}
+	return nil
+}
+
+// DeclareQueue creates the queue unless it exists, durability makes no difference since nothing is persisted
+func (b *MemoryBroker) DeclareQueue(ctx context.Context, queue Queue) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.declareQueue(queue.Name, queue.Args)
+	return nil
+}
+
+// DeclareExchange creates the exchange unless it exists, like RabbitMQ it fails when it exists with another kind
+func (b *MemoryBroker) DeclareExchange(ctx context.Context, exchange Exchange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.declareExchange(exchange.Name, exchange.Kind)
+}
+
+// BindQueue binds the queue to the exchange, both have to exist
+func (b *MemoryBroker) BindQueue(ctx context.Context, queueName, routingKey, exchange string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bind(queueName, routingKey, exchange)
+}
+
+// Consume passes the messages of the queue to the callback like ConsumeMessage does on RabbitMQ: with the same
+// workers and ordering, and with failed messages going through the retry queues and the dead-letter queue
+func (b *MemoryBroker) Consume(ctx context.Context, queue Queue, opts ConsumerOptions, callback func(amqp.Delivery) error) *Consumer {
+	opts = opts.withDefaults()
+	queueName := queue.Name
+	b.declareRetryTopology(queueName, opts.Retry)
+
+	return b.consume(ctx, queue, opts.Prefetch, func(msgs <-chan amqp.Delivery) {
+		dispatch(msgs, opts.Workers, opts.OrderingKey, func(d amqp.Delivery) {
+			settle(d, handleDelivery(b.Publish, queueName, opts.Retry, d, callback))
+		})
+	})
+}
+
+// ConsumeReplies passes the replies arriving on the queue to the callback one by one, a reply the callback failed
+// on is requeued after a backoff like ConsumeReplies does
+func (b *MemoryBroker) ConsumeReplies(ctx context.Context, queue Queue, callback func(correlationID string, body []byte, failure string) error) *Consumer {
+	replies := &replyHandler{callback: callback}
+	return b.consume(ctx, queue, 0, func(msgs <-chan amqp.Delivery) {
+		for d := range msgs {
+			replies.handle(ctx, d)
+		}
+	})
+}
+
+// RequestAll sends the requests and waits for the replies like Publisher.RequestAll. The replies arrive on a
+// queue of this call alone, which is removed afterwards; like RabbitMQ's direct reply-to it needs no
+// acknowledgements.
+func (b *MemoryBroker) RequestAll(ctx context.Context, messageType string, data interface{}, queues ...Queue) ([]Reply, error) {
+	envelope, err := events.NewEnvelope(messageType, data)
+	if err != nil {
+		return nil, err
+	}
+	request, err := envelopePublishing(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	replyQueue := b.declareQueue("amq.gen-"+primitive.NewObjectID().Hex(), nil)
+	b.mu.Unlock()
+	defer b.deleteQueue(replyQueue.name)
+	request.ReplyTo = replyQueue.name
+
+	replies := make([]Reply, len(queues))
+	pending := make(map[string]int, len(queues))
+	requestID := primitive.NewObjectID().Hex()
+	for i, queue := range queues {
+		replies[i] = Reply{Queue: queue.Name}
+		request.CorrelationId = requestID + "." + queue.Name
+		err := b.DeclareQueue(ctx, queue)
+		if err == nil {
+			err = b.Publish(ctx, "", queue.Name, true, request)
+		}
+		if err != nil {
+			replies[i].Status, replies[i].Err = ReplyFailed, fmt.Errorf("failed to publish request: %w", err)
+			replies[i].CompletedAt = time.Now().UTC()
+			continue
+		}
+		pending[request.CorrelationId] = i
+	}
+
+	for len(pending) > 0 {
+		d, err := b.get(ctx, replyQueue)
+		if err != nil {
+			status := ReplyFailed
+			if ctx.Err() != nil {
+				status = ReplyTimedOut
+			}
+			markPending(replies, pending, status, err)
+			return replies, nil
+		}
+		i, ok := pending[d.CorrelationId]
+		if !ok {
+			continue
+		}
+		delete(pending, d.CorrelationId)
+		replies[i].record(d)
+	}
+	return replies, nil
+}
+
+// Close stops the consumers and makes publishes fail, the messages still on the queues are kept
+func (b *MemoryBroker) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	return nil
+}
+
+// QueueLength returns the number of messages ready on the queue, e.g. to check whether a message ended up on the
+// dead-letter queue
+func (b *MemoryBroker) QueueLength(queueName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[queueName]; ok {
+		return len(q.ready)
+	}
+	return 0
+}
+
+// consume runs handle on the messages of the queue, which is declared when needed. The messages are delivered
+// until ctx is done or the broker is closed, the consumer is done once handle returned after that. Like a
+// RabbitMQ channel that is closed, the messages left unacknowledged then are requeued.
+func (b *MemoryBroker) consume(ctx context.Context, queue Queue, prefetch int, handle func(<-chan amqp.Delivery)) *Consumer {
+	b.mu.Lock()
+	q := b.declareQueue(queue.Name, queue.Args)
+	b.mu.Unlock()
+
+	tag := queue.Name + "." + primitive.NewObjectID().Hex()
+	consumer := &Consumer{name: queue.Name, done: make(chan struct{})}
+	msgs := make(chan amqp.Delivery)
+	go func() {
+		defer close(consumer.done)
+		handle(msgs)
+		b.requeueUnacked(tag)
+	}()
+
+	go func() {
+		defer close(msgs)
+		for {
+			d, changed, ok := b.next(q, tag, prefetch)
+			if ok {
+				select {
+				case msgs <- d:
+					continue
+				case <-ctx.Done():
+				case <-b.closed:
+				}
+				b.settle(d.DeliveryTag, false, settleRequeue)
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			case <-b.closed:
+				return
+			}
+		}
+	}()
+	return consumer
+}
+
+// next hands the first ready message of the queue to the consumer, unless it reached its prefetch count. When
+// there's nothing to deliver it returns a channel that is closed once that may have changed.
+func (b *MemoryBroker) next(q *memoryQueue, consumerTag string, prefetch int) (amqp.Delivery, <-chan struct{}, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(q.ready) == 0 || (prefetch > 0 && q.outstanding[consumerTag] >= prefetch) {
+		return amqp.Delivery{}, q.changed, false
+	}
+
+	m := q.ready[0]
+	q.ready = q.ready[1:]
+	b.lastTag++
+	b.unacked[b.lastTag] = &memoryDelivery{queue: q, consumerTag: consumerTag, message: m}
+	q.outstanding[consumerTag]++
+	return m.delivery(memoryAcknowledger{broker: b}, consumerTag, b.lastTag), nil, true
+}
+
+// get waits for the next message on the queue and takes it without acknowledgement
+func (b *MemoryBroker) get(ctx context.Context, q *memoryQueue) (amqp.Delivery, error) {
+	for {
+		b.mu.Lock()
+		if len(q.ready) > 0 {
+			m := q.ready[0]
+			q.ready = q.ready[1:]
+			b.mu.Unlock()
+			return m.delivery(nil, "", 0), nil
+		}
+		changed := q.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return amqp.Delivery{}, ctx.Err()
+		case <-b.closed:
+			return amqp.Delivery{}, ErrPublisherClosed
+		}
+	}
+}
+
+// settle settles the delivery with the tag, or with multiple all unsettled deliveries of its consumer up to it
+func (b *MemoryBroker) settle(tag uint64, multiple bool, how settlement) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.unacked[tag]
+	if !ok {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+
+	tags := []uint64{tag}
+	if multiple {
+		for other, od := range b.unacked {
+			if other < tag && od.consumerTag == d.consumerTag {
+				tags = append(tags, other)
+			}
+		}
+	}
+	b.settleAll(tags, how)
+	return nil
+}
+
+// requeueUnacked requeues the deliveries the consumer didn't settle
+func (b *MemoryBroker) requeueUnacked(consumerTag string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var tags []uint64
+	for tag, d := range b.unacked {
+		if d.consumerTag == consumerTag {
+			tags = append(tags, tag)
+		}
+	}
+	b.settleAll(tags, settleRequeue)
+}
+
+// settleAll settles the deliveries. Requeued messages go back to the front of their queue, in the order they
+// were delivered, so they're redelivered before the messages that came after them.
+func (b *MemoryBroker) settleAll(tags []uint64, how settlement) {
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, tag := range tags {
+		d := b.unacked[tag]
+		delete(b.unacked, tag)
+		q := d.queue
+		if q.outstanding[d.consumerTag]--; q.outstanding[d.consumerTag] <= 0 {
+			delete(q.outstanding, d.consumerTag)
+		}
+
+		switch how {
+		case settleRequeue:
+			d.message.redelivered = true
+			q.ready = append([]*memoryMessage{d.message}, q.ready...)
+		case settleReject:
+			b.deadLetter(q, d.message)
+		}
+		q.signal()
+	}
+}
+
+// memoryAcknowledger settles the deliveries of a MemoryBroker
+type memoryAcknowledger struct {
+	broker *MemoryBroker
+}
+
+func (a memoryAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.broker.settle(tag, multiple, settleAck)
+}
+
+func (a memoryAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		return a.broker.settle(tag, multiple, settleRequeue)
+	}
+	return a.broker.settle(tag, multiple, settleReject)
+}
+
+func (a memoryAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// declareRetryTopology declares the retry queues and the dead-letter exchange and queue, like retryTopology
+func (b *MemoryBroker) declareRetryTopology(queueName string, policy RetryPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for retry := 1; retry < policy.MaxAttempts; retry++ {
+		b.declareQueue(RetryQueue(queueName, retry), retryQueueArgs(queueName, policy, retry))
+	}
+	b.declareExchange(DeadLetterExchange(queueName), amqp.ExchangeDirect)
+	b.declareQueue(DeadLetterQueue(queueName), nil)
+	b.bind(DeadLetterQueue(queueName), queueName, DeadLetterExchange(queueName))
+}
+
+// The methods below expect the broker's mutex to be held
+
+// declareQueue returns the queue, creating it with the arguments when it doesn't exist yet
+func (b *MemoryBroker) declareQueue(name string, args amqp.Table) *memoryQueue {
+	if q, ok := b.queues[name]; ok {
+		return q
+	}
+	q := &memoryQueue{name: name, args: args, outstanding: make(map[string]int), changed: make(chan struct{})}
+	b.queues[name] = q
+	return q
+}
+
+func (b *MemoryBroker) declareExchange(name, kind string) error {
+	if name == "" {
+		return fmt.Errorf("the default exchange can't be declared")
+	}
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind {
+			return fmt.Errorf("exchange %s is a %s exchange, not %s", name, ex.kind, kind)
+		}
+		return nil
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic:
+	default:
+		return fmt.Errorf("exchange kind %s isn't supported", kind)
+	}
+	b.exchanges[name] = &memoryExchange{kind: kind}
+	return nil
+}
+
+func (b *MemoryBroker) bind(queueName, routingKey, exchange string) error {
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("exchange %s doesn't exist", exchange)
+	}
+	if _, ok := b.queues[queueName]; !ok {
+		return fmt.Errorf("queue %s doesn't exist", queueName)
+	}
+	binding := memoryBinding{queue: queueName, routingKey: routingKey}
+	for _, existing := range ex.bindings {
+		if existing == binding {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, binding)
+	return nil
+}
+
+// deleteQueue removes the queue along with its messages and bindings
+func (b *MemoryBroker) deleteQueue(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.queues, name)
+	for _, ex := range b.exchanges {
+		bindings := ex.bindings[:0]
+		for _, binding := range ex.bindings {
+			if binding.queue != name {
+				bindings = append(bindings, binding)
+			}
+		}
+		ex.bindings = bindings
+	}
+}
+
+// route returns the queues a message published to the exchange with the routing key ends up on
+func (b *MemoryBroker) route(exchange, routingKey string) ([]*memoryQueue, error) {
+	if exchange == "" {
+		if q, ok := b.queues[routingKey]; ok {
+			return []*memoryQueue{q}, nil
+		}
+		return nil, nil
+	}
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return nil, fmt.Errorf("exchange %s doesn't exist", exchange)
+	}
+	var queues []*memoryQueue
+	seen := make(map[string]bool)
+	for _, binding := range ex.bindings {
+		if seen[binding.queue] || !ex.matches(binding.routingKey, routingKey) {
+			continue
+		}
+		seen[binding.queue] = true
+		queues = append(queues, b.queues[binding.queue])
+	}
+	return queues, nil
+}
+
+// enqueue puts the message at the end of the queue. On a queue with a TTL the message is dead-lettered once
+// it has waited that long.
+func (b *MemoryBroker) enqueue(q *memoryQueue, m *memoryMessage) {
+	q.ready = append(q.ready, m)
+	if ttl := q.ttl(); ttl > 0 {
+		time.AfterFunc(ttl, func() { b.expire(q, m) })
+	}
+	q.signal()
+}
+
+// expire dead-letters the message when it's still waiting on the queue
+func (b *MemoryBroker) expire(q *memoryQueue, m *memoryMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, ready := range q.ready {
+		if ready == m {
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+			b.deadLetter(q, m)
+			return
+		}
+	}
+}
+
+// deadLetter republishes the message to the dead-letter exchange of the queue, without one it's dropped
+func (b *MemoryBroker) deadLetter(q *memoryQueue, m *memoryMessage) {
+	exchange, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	routingKey := m.routingKey
+	if key, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		routingKey = key
+	}
+
+	queues, _ := b.route(exchange, routingKey)
+	for _, target := range queues {
+		msg := copyPublishing(m.msg)
+		msg.Expiration = ""
+		b.enqueue(target, &memoryMessage{exchange: exchange, routingKey: routingKey, msg: msg})
+	}
+}
+
+func (b *MemoryBroker) isClosed() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// ttl returns how long messages may wait on the queue, zero when they may wait forever
+func (q *memoryQueue) ttl() time.Duration {
+	switch ttl := q.args["x-message-ttl"].(type) {
+	case int64:
+		return time.Duration(ttl) * time.Millisecond
+	case int32:
+		return time.Duration(ttl) * time.Millisecond
+	case int:
+		return time.Duration(ttl) * time.Millisecond
+	default:
+		return 0
+	}
+}
+
+// signal wakes up whoever is waiting for the queue to change
+func (q *memoryQueue) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// matches reports whether a message with the routing key is routed through a binding with the given key
+func (ex *memoryExchange) matches(bindingKey, routingKey string) bool {
+	switch ex.kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatches matches the words of a routing key against a topic pattern, where * stands for exactly one word
+// and # for zero or more words
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && words[0] == pattern[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+// delivery turns the message into a delivery for the consumer
+func (m *memoryMessage) delivery(acknowledger amqp.Acknowledger, consumerTag string, tag uint64) amqp.Delivery {
+	msg := copyPublishing(m.msg)
+	return amqp.Delivery{
+		Acknowledger:    acknowledger,
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		ConsumerTag:     consumerTag,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.routingKey,
+		Body:            msg.Body,
+	}
+}
+
+// copyPublishing copies the message, so neither the publisher nor a consumer can change what's on the queue
+func copyPublishing(msg amqp.Publishing) amqp.Publishing {
+	if msg.Headers != nil {
+		headers := make(amqp.Table, len(msg.Headers))
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+		msg.Headers = headers
+	}
+	msg.Body = append([]byte(nil), msg.Body...)
+	return msg
+}
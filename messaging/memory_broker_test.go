@@ -0,0 +1,324 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestMemoryBrokerAcknowledges(t *testing.T) {
+	b, ctx := newTestBroker(t)
+	queue := Queue{Name: "orders"}
+	if err := b.DeclareQueue(ctx, queue); err != nil {
+		t.Fatalf("DeclareQueue: %v", err)
+	}
+
+	handled := make(chan amqp.Delivery, 1)
+	b.Consume(ctx, queue, ConsumerOptions{}, func(d amqp.Delivery) error {
+		handled <- d
+		return nil
+	})
+	publish(t, b, "orders", amqp.Publishing{Body: []byte("hello")})
+
+	d := receive(t, handled)
+	if string(d.Body) != "hello" || d.Redelivered {
+		t.Errorf("Got %q with redelivered %t, want hello delivered for the first time", d.Body, d.Redelivered)
+	}
+	eventually(t, "the message is acknowledged", func() bool { return unacked(b) == 0 })
+	if n := b.QueueLength("orders"); n != 0 {
+		t.Errorf("%d message(s) left on the queue, want 0", n)
+	}
+}
+
+func TestMemoryBrokerRequeuedMessagesAreRedelivered(t *testing.T) {
+	b, ctx := newTestBroker(t)
+	queue := Queue{Name: "orders"}
+
+	deliveries := make(chan amqp.Delivery, 2)
+	b.consume(ctx, queue, 1, func(msgs <-chan amqp.Delivery) {
+		for d := range msgs {
+			deliveries <- d
+			if !d.Redelivered {
+				d.Nack(false, true)
+				continue
+			}
+			d.Ack(false)
+		}
+	})
+	publish(t, b, "orders", amqp.Publishing{Body: []byte("hello")})
+
+	first, second := receive(t, deliveries), receive(t, deliveries)
+	if first.Redelivered {
+		t.Error("The first delivery is marked as redelivered")
+	}
+	if !second.Redelivered || string(second.Body) != "hello" {
+		t.Errorf("Got %q with redelivered %t after requeueing, want hello redelivered", second.Body, second.Redelivered)
+	}
+	eventually(t, "the redelivery is acknowledged", func() bool { return unacked(b) == 0 })
+}
+
+func TestMemoryBrokerRequeuesUnacknowledgedMessagesOfStoppedConsumer(t *testing.T) {
+	b, ctx := newTestBroker(t)
+	queue := Queue{Name: "orders"}
+
+	consumerCtx, stop := context.WithCancel(ctx)
+	received := make(chan amqp.Delivery, 1)
+	consumer := b.consume(consumerCtx, queue, 1, func(msgs <-chan amqp.Delivery) {
+		for d := range msgs {
+			// Never settled, like a consumer that dies while handling the message
+			received <- d
+		}
+	})
+	publish(t, b, "orders", amqp.Publishing{Body: []byte("hello")})
+	receive(t, received)
+	stop()
+	if err := consumer.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	redelivered := make(chan amqp.Delivery, 1)
+	b.Consume(ctx, queue, ConsumerOptions{}, func(d amqp.Delivery) error {
+		redelivered <- d
+		return nil
+	})
+	if d := receive(t, redelivered); !d.Redelivered {
+		t.Error("The message left unacknowledged isn't marked as redelivered")
+	}
+}
+
+func TestMemoryBrokerRetriesAndDeadLetters(t *testing.T) {
+	t.Run("after the last attempt", func(t *testing.T) {
+		b, ctx := newTestBroker(t)
+		policy := RetryPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond}
+
+		var attempts int32
+		b.Consume(ctx, Queue{Name: "orders"}, ConsumerOptions{Retry: policy}, func(d amqp.Delivery) error {
+			if got, want := attemptOf(d), int(atomic.AddInt32(&attempts, 1)); got != want {
+				t.Errorf("Delivery is attempt %d, want %d", got, want)
+			}
+			return errors.New("database down")
+		})
+		publish(t, b, "orders", amqp.Publishing{Body: []byte("hello")})
+
+		eventually(t, "the message is dead-lettered", func() bool { return b.QueueLength(DeadLetterQueue("orders")) == 1 })
+		if n := atomic.LoadInt32(&attempts); n != 3 {
+			t.Errorf("The message was handled %d time(s), want 3", n)
+		}
+		dead := take(t, b, DeadLetterQueue("orders"))
+		if string(dead.Body) != "hello" {
+			t.Errorf("Dead-lettered body is %q, want hello", dead.Body)
+		}
+		if attemptOf(dead) != 3 || dead.Headers[LastErrorHeader] != "database down" || dead.Headers[OriginalQueueHeader] != "orders" {
+			t.Errorf("Dead-lettered message has headers %v", dead.Headers)
+		}
+		for retry := 1; retry < policy.MaxAttempts; retry++ {
+			if n := b.QueueLength(RetryQueue("orders", retry)); n != 0 {
+				t.Errorf("%d message(s) left on retry queue %d", n, retry)
+			}
+		}
+	})
+
+	t.Run("right away when the error is permanent", func(t *testing.T) {
+		b, ctx := newTestBroker(t)
+		policy := RetryPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond}
+
+		var attempts int32
+		b.Consume(ctx, Queue{Name: "orders"}, ConsumerOptions{Retry: policy}, func(d amqp.Delivery) error {
+			atomic.AddInt32(&attempts, 1)
+			return Permanent(errors.New("malformed JSON"))
+		})
+		publish(t, b, "orders", amqp.Publishing{Body: []byte("{")})
+
+		eventually(t, "the message is dead-lettered", func() bool { return b.QueueLength(DeadLetterQueue("orders")) == 1 })
+		if n := atomic.LoadInt32(&attempts); n != 1 {
+			t.Errorf("The message was handled %d time(s), want 1", n)
+		}
+	})
+}
+
+func TestMemoryBrokerRequestAll(t *testing.T) {
+	b, ctx := newTestBroker(t)
+	auth, authz, history := Queue{Name: "auth_queue"}, Queue{Name: "authz_queue"}, Queue{Name: "watch_history_queue"}
+
+	// auth answers, authz reports a failure and watch_history never replies
+	b.Consume(ctx, auth, ConsumerOptions{}, func(d amqp.Delivery) error {
+		if userID := peekUserID(d.Body); userID != "user-1" {
+			t.Errorf("Request is about %q, want user-1", userID)
+		}
+		return b.Publish(ctx, "", d.ReplyTo, false, amqp.Publishing{CorrelationId: d.CorrelationId, Body: []byte(`{"sessions":2}`)})
+	})
+	b.Consume(ctx, authz, ConsumerOptions{}, func(d amqp.Delivery) error {
+		return b.Publish(ctx, "", d.ReplyTo, false, amqp.Publishing{
+			CorrelationId: d.CorrelationId,
+			Headers:       amqp.Table{ReplyErrorHeader: "database down"},
+		})
+	})
+
+	requestCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	replies, err := b.RequestAll(requestCtx, TypeGetAllRecords, map[string]string{"user_id": "user-1"}, auth, authz, history)
+	if err != nil {
+		t.Fatalf("RequestAll: %v", err)
+	}
+	if len(replies) != 3 {
+		t.Fatalf("Got %d replies, want 3", len(replies))
+	}
+
+	if r := replies[0]; r.Queue != "auth_queue" || r.Status != ReplyAnswered || string(r.Body) != `{"sessions":2}` {
+		t.Errorf("auth reply is %+v, want the answer", r)
+	}
+	if r := replies[1]; r.Status != ReplyFailed || r.Err == nil || r.Err.Error() != "database down" {
+		t.Errorf("authz reply is %+v, want the reported failure", r)
+	}
+	if r := replies[2]; r.Status != ReplyTimedOut || !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Errorf("watch_history reply is %+v, want a timeout", r)
+	}
+	for _, r := range replies {
+		if r.CompletedAt.IsZero() {
+			t.Errorf("Reply of %s has no completion time", r.Queue)
+		}
+	}
+
+	// The reply queue only lives as long as the call
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range b.queues {
+		if strings.HasPrefix(name, "amq.gen-") {
+			t.Errorf("Reply queue %s is left behind", name)
+		}
+	}
+}
+
+func TestMemoryBrokerKeepsOrderPerKey(t *testing.T) {
+	b, ctx := newTestBroker(t)
+	const keys, perKey = 5, 20
+
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	done := make(chan struct{})
+	opts := ConsumerOptions{
+		Workers:     4,
+		Prefetch:    8,
+		OrderingKey: func(d amqp.Delivery) string { return d.Headers["key"].(string) },
+	}
+	b.Consume(ctx, Queue{Name: "orders"}, opts, func(d amqp.Delivery) error {
+		// Take a little while, so messages of other keys overtake each other
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		key := d.Headers["key"].(string)
+		seen[key] = append(seen[key], int(d.Headers["seq"].(int32)))
+		if total(seen) == keys*perKey {
+			close(done)
+		}
+		return nil
+	})
+
+	for seq := 0; seq < perKey; seq++ {
+		for key := 0; key < keys; key++ {
+			publish(t, b, "orders", amqp.Publishing{Headers: amqp.Table{"key": fmt.Sprintf("user-%d", key), "seq": int32(seq)}})
+		}
+	}
+	receive(t, done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, order := range seen {
+		for i, seq := range order {
+			if seq != i {
+				t.Errorf("Messages of %s were handled in the order %v", key, order)
+				break
+			}
+		}
+	}
+}
+
+// newTestBroker returns a broker that is closed once the test is done, along with a context that is cancelled
+// then, which stops the consumers of the test
+func newTestBroker(t *testing.T) (*MemoryBroker, context.Context) {
+	b := NewMemoryBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		b.Close(context.Background())
+	})
+	return b, ctx
+}
+
+// publish sends the message straight to the queue, which is declared when needed
+func publish(t *testing.T, b *MemoryBroker, queueName string, msg amqp.Publishing) {
+	t.Helper()
+	ctx := context.Background()
+	if err := b.DeclareQueue(ctx, Queue{Name: queueName}); err != nil {
+		t.Fatalf("DeclareQueue: %v", err)
+	}
+	if err := b.Publish(ctx, "", queueName, true, msg); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+// take removes the first message from the queue
+func take(t *testing.T, b *MemoryBroker, queueName string) amqp.Delivery {
+	t.Helper()
+	b.mu.Lock()
+	q, ok := b.queues[queueName]
+	b.mu.Unlock()
+	if !ok {
+		t.Fatalf("Queue %s doesn't exist", queueName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d, err := b.get(ctx, q)
+	if err != nil {
+		t.Fatalf("No message on %s: %v", queueName, err)
+	}
+	return d
+}
+
+// receive waits for a value on the channel, or fails the test after a second
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting")
+		var zero T
+		return zero
+	}
+}
+
+// eventually fails the test when the condition doesn't hold within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func unacked(b *MemoryBroker) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.unacked)
+}
+
+func total(seen map[string][]int) int {
+	var n int
+	for _, order := range seen {
+		n += len(order)
+	}
+	return n
+}
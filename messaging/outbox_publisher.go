@@ -0,0 +1,50 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var _ outbox.Publisher = (*OutboxPublisher)(nil)
+
+// OutboxPublisher publishes outbox messages through the broker
+type OutboxPublisher struct {
+	broker Broker
+	// topology tells how the queues and exchanges the messages go to are declared
+	topology Topology
+}
+
+// NewOutboxPublisher creates an outbox publisher on top of the broker
+func NewOutboxPublisher(broker Broker, topology Topology) *OutboxPublisher {
+	return &OutboxPublisher{broker: broker, topology: topology}
+}
+
+// Publish sends the message and returns an error when the broker didn't confirm it, so the relay can retry it later
+func (p *OutboxPublisher) Publish(ctx context.Context, message *outbox.Message) error {
+	// Messages for the default exchange go straight to a queue, make sure it exists.
+	// Other messages are events for a topic exchange, subscribers bind their own queues to it.
+	if message.Exchange == "" {
+		if err := p.broker.DeclareQueue(ctx, p.topology.Queue(message.RoutingKey)); err != nil {
+			return err
+		}
+	} else {
+		if err := p.broker.DeclareExchange(ctx, p.topology.Exchange(message.Exchange)); err != nil {
+			return err
+		}
+	}
+
+	// Messages sent straight to a queue are commands that must arrive, so they're mandatory. Events may
+	// have no subscribers at all, which isn't an error.
+	mandatory := message.Exchange == ""
+	return p.broker.Publish(ctx, message.Exchange, message.RoutingKey, mandatory, amqp.Publishing{
+		ContentType:   message.ContentType,
+		MessageId:     message.ID.Hex(),
+		Timestamp:     message.CreatedAt,
+		DeliveryMode:  amqp.Persistent,
+		ReplyTo:       message.ReplyTo,
+		CorrelationId: message.CorrelationID,
+		Body:          message.Payload,
+	})
+}
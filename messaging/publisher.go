@@ -0,0 +1,287 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrUnroutable is returned when the broker returned a mandatory message because no queue was bound to receive it
+var ErrUnroutable = errors.New("message could not be routed to a queue")
+
+// ErrNacked is returned when the broker refused to take responsibility for a message
+var ErrNacked = errors.New("message was not acknowledged by the broker")
+
+// ErrPublisherClosed is returned by publishes once the publisher has been closed
+var ErrPublisherClosed = errors.New("publisher closed")
+
+// PublisherOptions tune the shared publisher
+type PublisherOptions struct {
+	// PoolSize is the number of channels publishes share
+	PoolSize int
+	// MaxRequests is the number of requests waiting for replies at the same time, each has a channel of its own
+	// outside the pool. It defaults to PoolSize.
+	MaxRequests int
+	// MaxAttempts is how often a publish is attempted before its error is returned, RetryDelay the pause in between.
+	// Unroutable messages aren't retried, they'd be returned again.
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+// Publisher publishes messages over the shared connection of a connection manager. It keeps a pool of open
+// channels and remembers which queues and exchanges it already declared, so a publish costs a single round trip.
+// Channels are in confirm mode, a publish only succeeds once the broker confirmed it.
+// It's safe for concurrent use, at most PoolSize publishes use a channel at the same time.
+type Publisher struct {
+	manager *ConnectionManager
+	options PublisherOptions
+	// slots limits the number of channels in use, idle holds the open channels waiting to be reused
+	slots chan struct{}
+	idle  chan *confirmChannel
+	// requests limits the number of requests waiting for replies, they don't hold up the pool
+	requests chan struct{}
+
+	mu       sync.Mutex
+	declared map[string]bool
+
+	// closed is closed by Close, publishes fail from then on
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// confirmChannel is a channel in confirm mode along with the messages the broker returned on it
+type confirmChannel struct {
+	*amqp.Channel
+	returns chan amqp.Return
+}
+
+// NewPublisher creates a publisher on top of the manager's connection
+func NewPublisher(manager *ConnectionManager, options PublisherOptions) *Publisher {
+	if options.PoolSize < 1 {
+		options.PoolSize = 1
+	}
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+	if options.MaxRequests < 1 {
+		options.MaxRequests = options.PoolSize
+	}
+	p := &Publisher{
+		manager:  manager,
+		options:  options,
+		slots:    make(chan struct{}, options.PoolSize),
+		idle:     make(chan *confirmChannel, options.PoolSize),
+		requests: make(chan struct{}, options.MaxRequests),
+		declared: make(map[string]bool),
+		closed:   make(chan struct{}),
+	}
+	// Channels and declarations don't survive the connection, start over once it's lost
+	manager.OnStateChange(func(state ConnectionState, err error) {
+		if state != StateConnected {
+			p.reset()
+		}
+	})
+	return p
+}
+
+// Publish sends the message to the exchange with the given routing key and waits for the broker to confirm it.
+// A mandatory message that can't be routed to any queue fails with ErrUnroutable instead of being dropped.
+func (p *Publisher) Publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	var err error
+	for attempt := 1; attempt <= p.options.MaxAttempts; attempt++ {
+		if err = p.publish(ctx, exchange, routingKey, mandatory, msg); err == nil || errors.Is(err, ErrUnroutable) {
+			return err
+		}
+		if attempt == p.options.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(p.options.RetryDelay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+	return err
+}
+
+func (p *Publisher) publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	return p.withChannel(ctx, func(ch *confirmChannel) error {
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, mandatory, false, msg)
+		if err != nil {
+			return fmt.Errorf("failed to publish a message: %v", err)
+		}
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for the publish confirmation: %v", err)
+		}
+
+		// The broker sends a return before the confirmation of the same message, and the channel is only used
+		// by one publish at a time, so a return waiting now belongs to this message
+		select {
+		case returned := <-ch.returns:
+			return fmt.Errorf("%w: %s to %q (%d %s)", ErrUnroutable, returned.RoutingKey, returned.Exchange, returned.ReplyCode, returned.ReplyText)
+		default:
+		}
+		if !acked {
+			return ErrNacked
+		}
+		return nil
+	})
+}
+
+// DeclareQueue declares the queue unless the publisher already did so on the current connection
+func (p *Publisher) DeclareQueue(ctx context.Context, queue Queue) error {
+	return p.declareOnce(ctx, "queue:"+queue.Name, func(ch *confirmChannel) error {
+		if _, err := ch.QueueDeclare(queue.Name, queue.Durable, false, false, false, queue.Args); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %v", queue.Name, err)
+		}
+		return nil
+	})
+}
+
+// DeclareExchange declares the exchange unless the publisher already did so on the current connection
+func (p *Publisher) DeclareExchange(ctx context.Context, exchange Exchange) error {
+	return p.declareOnce(ctx, "exchange:"+exchange.Name, func(ch *confirmChannel) error {
+		if err := ch.ExchangeDeclare(exchange.Name, exchange.Kind, exchange.Durable, false, false, false, exchange.Args); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %v", exchange.Name, err)
+		}
+		return nil
+	})
+}
+
+// BindQueue binds the queue to the exchange with the routing key unless the publisher already did so on the current connection
+func (p *Publisher) BindQueue(ctx context.Context, queueName, routingKey, exchange string) error {
+	return p.declareOnce(ctx, "binding:"+exchange+":"+routingKey+":"+queueName, func(ch *confirmChannel) error {
+		if err := ch.QueueBind(queueName, routingKey, exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %v", queueName, exchange, err)
+		}
+		return nil
+	})
+}
+
+func (p *Publisher) declareOnce(ctx context.Context, key string, declare func(*confirmChannel) error) error {
+	p.mu.Lock()
+	done := p.declared[key]
+	p.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	if err := p.withChannel(ctx, declare); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.declared[key] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// withChannel runs fn on a channel from the pool. A channel that failed is closed rather than reused, since
+// AMQP closes a channel on most errors and an unconfirmed publish could still be confirmed later on.
+func (p *Publisher) withChannel(ctx context.Context, fn func(*confirmChannel) error) error {
+	if err := p.takeSlot(ctx, p.slots); err != nil {
+		return err
+	}
+	defer func() { <-p.slots }()
+
+	ch, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	if err := fn(ch); err != nil {
+		ch.Close()
+		return err
+	}
+	p.release(ch)
+	return nil
+}
+
+// acquire returns an idle channel that is still open, or opens a new one in confirm mode
+func (p *Publisher) acquire() (*confirmChannel, error) {
+	for {
+		select {
+		case ch := <-p.idle:
+			if !ch.IsClosed() {
+				return ch, nil
+			}
+		default:
+			ch, err := p.manager.Channel()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open a channel: %v", err)
+			}
+			if err := ch.Confirm(false); err != nil {
+				ch.Close()
+				return nil, fmt.Errorf("failed to put channel in confirm mode: %v", err)
+			}
+			return &confirmChannel{Channel: ch, returns: ch.NotifyReturn(make(chan amqp.Return, 1))}, nil
+		}
+	}
+}
+
+// release puts the channel back in the pool
+func (p *Publisher) release(ch *confirmChannel) {
+	select {
+	case p.idle <- ch:
+	default:
+		ch.Close()
+	}
+}
+
+// reset forgets the declarations and closes the idle channels
+func (p *Publisher) reset() {
+	p.mu.Lock()
+	p.declared = make(map[string]bool)
+	p.mu.Unlock()
+
+	for {
+		select {
+		case ch := <-p.idle:
+			ch.Close()
+		default:
+			return
+		}
+	}
+}
+
+// takeSlot waits for a free slot, of the pool or the requests, it fails when ctx is done or the publisher is closed
+func (p *Publisher) takeSlot(ctx context.Context, slots chan struct{}) error {
+	select {
+	case <-p.closed:
+		return ErrPublisherClosed
+	default:
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closed:
+		return ErrPublisherClosed
+	}
+}
+
+// Close stops accepting publishes, waits until the publishes in flight are confirmed and closes the idle
+// channels. Requests waiting for replies give up on them. It returns an error when ctx is done before the
+// publishes and requests in flight are. The connection itself belongs to the connection manager.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	defer p.reset()
+
+	// Every publish and request holds a slot, so once all slots are taken nothing is in flight anymore
+	for _, slots := range []chan struct{}{p.slots, p.requests} {
+		for i := 0; i < cap(slots); i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return fmt.Errorf("publishes still in flight: %w", ctx.Err())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// directReplyTo is RabbitMQ's pseudo queue for replies, it needs no declaration and routes replies
+// straight back to the channel that published the request
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// ReplyErrorHeader is set by a service on its reply when it couldn't handle the request
+const ReplyErrorHeader = "error"
+
+// ReplyStatus tells how a service responded to a request
+type ReplyStatus int
+
+const (
+	ReplyAnswered ReplyStatus = iota
+	ReplyFailed
+	ReplyTimedOut
+)
+
+// String returns the name of the status as used in exports and logs
+func (s ReplyStatus) String() string {
+	switch s {
+	case ReplyAnswered:
+		return "answered"
+	case ReplyFailed:
+		return "failed"
+	case ReplyTimedOut:
+		return "timed_out"
+	default:
+		return "unknown"
+	}
+}
+
+// Reply is the outcome of a request to a single queue
+type Reply struct {
+	Queue  string
+	Status ReplyStatus
+	Body   []byte
+	// Err explains why the request failed or timed out
+	Err error
+	// CompletedAt is when the reply arrived, or when the request failed or timed out
+	CompletedAt time.Time
+}
+
+// RequestAll sends an envelope of the given type carrying data to every queue and waits for their replies until
+// all have answered or the context is done. Every request carries its own correlation id, so concurrent calls
+// never see each other's replies. The replies are returned in the order of queues, with the data of the reply
+// envelopes as their body.
+//
+// Replies arrive on the channel that published the requests, so every call has a channel of its own rather than
+// one of the pool; waiting for slow services doesn't hold up other publishes. At most MaxRequests calls wait
+// at the same time. Once the publisher is closed, the requests still waiting for a reply fail.
+//
+// The requests are mandatory and confirmed like other publishes. A request the broker returns because the queue
+// doesn't exist, or doesn't accept, fails right away instead of timing out.
+func (p *Publisher) RequestAll(ctx context.Context, messageType string, data interface{}, queues ...Queue) ([]Reply, error) {
+	envelope, err := events.NewEnvelope(messageType, data)
+	if err != nil {
+		return nil, err
+	}
+	request, err := envelopePublishing(envelope)
+	if err != nil {
+		return nil, err
+	}
+	request.ReplyTo = directReplyTo
+
+	replies := make([]Reply, len(queues))
+	declared := make([]bool, len(queues))
+	for i, queue := range queues {
+		replies[i] = Reply{Queue: queue.Name}
+		if err := p.DeclareQueue(ctx, queue); err != nil {
+			replies[i].Status, replies[i].Err = ReplyFailed, err
+			replies[i].CompletedAt = time.Now().UTC()
+			continue
+		}
+		declared[i] = true
+	}
+
+	if err := p.takeSlot(ctx, p.requests); err != nil {
+		return nil, err
+	}
+	defer func() { <-p.requests }()
+	ch, err := p.manager.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to put channel in confirm mode: %v", err)
+	}
+	// Every request is returned at most once and the returns are read after each confirmation, so they never
+	// block the connection
+	returns := ch.NotifyReturn(make(chan amqp.Return, len(queues)))
+
+	// Consuming from the reply pseudo queue has to start before publishing, it only works in auto-ack mode
+	deliveries, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume replies: %v", err)
+	}
+
+	pending := make(map[string]int, len(queues))
+	requestID := primitive.NewObjectID().Hex()
+	for i, queue := range queues {
+		if !declared[i] {
+			continue
+		}
+		correlationID := requestID + "." + queue.Name
+		request.CorrelationId = correlationID
+		if err := publishRequest(ctx, ch, returns, queue.Name, request); err != nil {
+			replies[i].Status, replies[i].Err = ReplyFailed, fmt.Errorf("failed to publish request: %w", err)
+			replies[i].CompletedAt = time.Now().UTC()
+			continue
+		}
+		pending[correlationID] = i
+	}
+
+	for len(pending) > 0 {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				markPending(replies, pending, ReplyFailed, fmt.Errorf("channel closed before a reply arrived"))
+				return replies, nil
+			}
+			i, ok := pending[d.CorrelationId]
+			if !ok {
+				// A late reply to an earlier request that was published on this channel, ignore it
+				continue
+			}
+			delete(pending, d.CorrelationId)
+			replies[i].record(d)
+		case <-ctx.Done():
+			markPending(replies, pending, ReplyTimedOut, ctx.Err())
+			return replies, nil
+		case <-p.closed:
+			markPending(replies, pending, ReplyFailed, ErrPublisherClosed)
+			return replies, nil
+		}
+	}
+	return replies, nil
+}
+
+// publishRequest publishes the mandatory request to the queue and waits for the broker to confirm it. It fails
+// with ErrUnroutable when the broker returned the request and ErrNacked when it didn't accept it.
+func publishRequest(ctx context.Context, ch *amqp.Channel, returns <-chan amqp.Return, queueName string, request amqp.Publishing) error {
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queueName, true, false, request)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wait for the publish confirmation: %v", err)
+	}
+
+	// The broker sends a return before the confirmation of the same message, and the requests are published one
+	// at a time, so a return waiting now belongs to this request
+	select {
+	case returned := <-returns:
+		return fmt.Errorf("%w: %s (%d %s)", ErrUnroutable, returned.RoutingKey, returned.ReplyCode, returned.ReplyText)
+	default:
+	}
+	if !acked {
+		return ErrNacked
+	}
+	return nil
+}
+
+// record fills in the reply from its delivery, the error header makes it a failure
+func (r *Reply) record(d amqp.Delivery) {
+	r.Body = replyData(d.Body)
+	r.CompletedAt = time.Now().UTC()
+	if reason, ok := d.Headers[ReplyErrorHeader]; ok {
+		r.Status, r.Err = ReplyFailed, fmt.Errorf("%v", reason)
+	} else {
+		r.Status = ReplyAnswered
+	}
+}
+
+// markPending gives every request still waiting for a reply the same outcome
+func markPending(replies []Reply, pending map[string]int, status ReplyStatus, err error) {
+	completedAt := time.Now().UTC()
+	for _, i := range pending {
+		replies[i].Status, replies[i].Err = status, err
+		replies[i].CompletedAt = completedAt
+	}
+}
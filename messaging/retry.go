@@ -0,0 +1,141 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers added to messages that are retried or dead-lettered
+const (
+	// AttemptHeader counts how often the message has been handled, the first delivery is attempt 1
+	AttemptHeader = "x-attempt"
+	// LastErrorHeader is the error of the most recent attempt
+	LastErrorHeader = "x-last-error"
+	// OriginalQueueHeader is the queue the message was consumed from
+	OriginalQueueHeader = "x-original-queue"
+	// FailedAtHeader is when the message was dead-lettered
+	FailedAtHeader = "x-failed-at"
+)
+
+// RetryPolicy decides how often and after how long a message that failed is handled again
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a message is handled before it's dead-lettered
+	MaxAttempts int
+	// Delay is the wait before the first retry, it doubles with every following retry
+	Delay time.Duration
+}
+
+// delay returns the wait before the given retry, retries are numbered from 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	return time.Duration(float64(p.Delay) * math.Pow(2, float64(retry-1)))
+}
+
+// RetryQueue is the queue holding messages of the queue waiting for the given retry. Every retry has its own
+// queue with a fixed TTL, after which the broker dead-letters the message back onto the original queue.
+func RetryQueue(queueName string, retry int) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, retry)
+}
+
+// DeadLetterExchange is the exchange messages of the queue are sent to once they exhausted their retries
+func DeadLetterExchange(queueName string) string {
+	return queueName + ".dlx"
+}
+
+// DeadLetterQueue is the queue collecting the dead-lettered messages of the queue
+func DeadLetterQueue(queueName string) string {
+	return queueName + ".dlq"
+}
+
+// retryTopology declares the retry queues and the dead-letter exchange and queue of the queue
+func retryTopology(queueName string, policy RetryPolicy) TopologyFunc {
+	return func(ch *amqp.Channel) error {
+		for retry := 1; retry < policy.MaxAttempts; retry++ {
+			_, err := ch.QueueDeclare(RetryQueue(queueName, retry), true, false, false, false, retryQueueArgs(queueName, policy, retry))
+			if err != nil {
+				return fmt.Errorf("failed to declare retry queue: %v", err)
+			}
+		}
+
+		if err := ch.ExchangeDeclare(DeadLetterExchange(queueName), amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare dead-letter exchange: %v", err)
+		}
+		if _, err := ch.QueueDeclare(DeadLetterQueue(queueName), true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare dead-letter queue: %v", err)
+		}
+		return ch.QueueBind(DeadLetterQueue(queueName), queueName, DeadLetterExchange(queueName), false, nil)
+	}
+}
+
+// retryQueueArgs are the arguments of the retry queue: the message waits for the delay of the retry, then the
+// broker dead-letters it through the default exchange back onto the original queue
+func retryQueueArgs(queueName string, policy RetryPolicy, retry int) amqp.Table {
+	return amqp.Table{
+		"x-message-ttl":             policy.delay(retry).Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queueName,
+	}
+}
+
+// permanentError marks an error that won't go away by trying again
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as permanent, a message failing with it is dead-lettered right away instead of retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// attemptOf returns the attempt the delivery is, based on the header set when it was retried
+func attemptOf(d amqp.Delivery) int {
+	switch attempt := d.Headers[AttemptHeader].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case int:
+		return attempt
+	default:
+		return 1
+	}
+}
+
+// republishing copies the delivery into a new message with the given extra headers
+func republishing(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	merged := amqp.Table{}
+	for key, value := range d.Headers {
+		merged[key] = value
+	}
+	for key, value := range headers {
+		merged[key] = value
+	}
+	return amqp.Publishing{
+		Headers:         merged,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
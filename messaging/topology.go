@@ -0,0 +1,123 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Queue is a queue along with how it's declared. Declaring a queue that exists with other settings fails, so
+// they have to match the queue on the broker.
+type Queue struct {
+	Name    string
+	Durable bool
+	// Args are the optional arguments, e.g. x-queue-type or x-message-ttl
+	Args amqp.Table
+}
+
+// Exchange is an exchange along with how it's declared
+type Exchange struct {
+	Name    string
+	Kind    string
+	Durable bool
+	Args    amqp.Table
+}
+
+// Downstream is another service that keeps data about users, it's asked for that data and to erase it through
+// its queue
+type Downstream struct {
+	Service string
+	Queue   Queue
+}
+
+// Topology names the queues and exchanges the service works with and tells how they're declared. The retry
+// queues and the dead-letter exchange and queue are named after the queue they belong to.
+type Topology struct {
+	// UserQueue receives the messages changing and reading users
+	UserQueue Queue
+	// DeletionReplies receives the confirmations of the downstream services that erased a user
+	DeletionReplies Queue
+	// Events is the topic exchange user events are published to
+	Events Exchange
+	// Downstream are the other services, in the order their sections appear in the user data
+	Downstream []Downstream
+}
+
+// Queue returns how the queue with the given name is declared, a queue the topology doesn't know is declared
+// non-durable without arguments
+func (t Topology) Queue(name string) Queue {
+	for _, queue := range t.queues() {
+		if queue.Name == name {
+			return queue
+		}
+	}
+	return Queue{Name: name}
+}
+
+// Exchange returns how the exchange with the given name is declared, an exchange the topology doesn't know is
+// declared as a durable topic exchange without arguments
+func (t Topology) Exchange(name string) Exchange {
+	if t.Events.Name == name {
+		return t.Events
+	}
+	return Exchange{Name: name, Kind: amqp.ExchangeTopic, Durable: true}
+}
+
+func (t Topology) queues() []Queue {
+	queues := []Queue{t.UserQueue, t.DeletionReplies}
+	for _, downstream := range t.Downstream {
+		queues = append(queues, downstream.Queue)
+	}
+	return queues
+}
+
+// ParseArgs parses queue or exchange arguments given as a JSON object, e.g. {"x-queue-type": "quorum"}.
+// Whole numbers become integers, since RabbitMQ rejects e.g. a TTL that is a float. An empty string means
+// no arguments.
+func ParseArgs(s string) (amqp.Table, error) {
+	if s == "" {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(s)))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("arguments aren't a JSON object: %v", err)
+	}
+
+	args := make(amqp.Table, len(raw))
+	for key, value := range raw {
+		args[key] = argValue(value)
+	}
+	if err := args.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	return args, nil
+}
+
+// argValue converts a decoded JSON value into a value AMQP tables can hold
+func argValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		table := make(amqp.Table, len(value))
+		for key, nested := range value {
+			table[key] = argValue(nested)
+		}
+		return table
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = argValue(nested)
+		}
+		return value
+	default:
+		return value
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/config"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/migrations"
+	mongodb "github.com/Portfolio-Advanced-software/BingeBuster-UserService/mongodb"
+)
+
+// runMigrateCommand implements `migrate [up|down|status]`, it connects to MongoDB and exits when done
+func runMigrateCommand(c config.Config, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	target := fs.Int("target", 0, "highest version to apply when migrating up (0 = all)")
+	steps := fs.Int("steps", 1, "number of migrations to roll back when migrating down")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: app migrate [up|down|status] [flags]")
+		fs.PrintDefaults()
+	}
+
+	action := "up"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	client := mongodb.ConnectToMongoDB(mongoDBUrl(c))
+	defer client.Disconnect(context.Background())
+
+	ctx := context.Background()
+	migrator := migrations.NewMigrator(client.Database(c.MongoDBDb), c.MongoDBCollection)
+	opts := migrations.Options{DryRun: *dryRun, Target: *target, Steps: *steps}
+
+	switch action {
+	case "up":
+		if err := migrator.Up(ctx, opts); err != nil {
+			log.Fatalf("Migrating up failed: %v", err)
+		}
+	case "down":
+		if err := migrator.Down(ctx, opts); err != nil {
+			log.Fatalf("Migrating down failed: %v", err)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-28s %s\n", st.Migration.Version, state, st.Migration.Description)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// legacyFieldNames maps the keys written by the old saveRecord message handler onto the keys of models.User
+var legacyFieldNames = map[string]string{
+	"user_id":           "userid",
+	"date_of_birth":     "dateofbirth",
+	"first_name":        "firstname",
+	"last_name":         "lastname",
+	"creditcard_number": "creditcardnumber",
+	"expiration_date":   "expirationdate",
+}
+
+func init() {
+	register(Migration{
+		Version:     1,
+		Description: "Normalize documents inserted by the legacy saveRecord message handler",
+		Up:          normalizeLegacyRecordsUp,
+		Down:        normalizeLegacyRecordsDown,
+	})
+}
+
+func normalizeLegacyRecordsUp(ctx context.Context, env *Env) error {
+	// Rename the snake_case keys, but never overwrite a value that is already stored under the new key
+	for legacy, current := range legacyFieldNames {
+		filter := bson.M{legacy: bson.M{"$exists": true}, current: bson.M{"$exists": false}}
+		if env.DryRun {
+			count, err := env.Users.CountDocuments(ctx, filter)
+			if err != nil {
+				return err
+			}
+			env.Logf("Would rename %s to %s on %d document(s)", legacy, current, count)
+			continue
+		}
+
+		result, err := env.Users.UpdateMany(ctx, filter, bson.M{"$rename": bson.M{legacy: current}})
+		if err != nil {
+			return err
+		}
+		env.Logf("Renamed %s to %s on %d document(s)", legacy, current, result.ModifiedCount)
+	}
+
+	// The action of the message was stored along with the user, it isn't part of the user itself
+	filter := bson.M{"action": bson.M{"$exists": true}}
+	if env.DryRun {
+		count, err := env.Users.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		env.Logf("Would remove the action field from %d document(s)", count)
+		return nil
+	}
+
+	result, err := env.Users.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"action": ""}})
+	if err != nil {
+		return err
+	}
+	env.Logf("Removed the action field from %d document(s)", result.ModifiedCount)
+	return nil
+}
+
+// normalizeLegacyRecordsDown puts the action field back on documents created through the message queue,
+// recognisable by their external user id. The renamed keys are kept since every reader uses the new names.
+func normalizeLegacyRecordsDown(ctx context.Context, env *Env) error {
+	filter := bson.M{"userid": bson.M{"$exists": true}, "action": bson.M{"$exists": false}}
+	if env.DryRun {
+		count, err := env.Users.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		env.Logf("Would restore the action field on %d document(s)", count)
+		return nil
+	}
+
+	result, err := env.Users.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"action": "saveRecord"}})
+	if err != nil {
+		return err
+	}
+	env.Logf("Restored the action field on %d document(s)", result.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func init() {
+	register(Migration{
+		Version:     2,
+		Description: "Backfill the version used for optimistic concurrency on existing users",
+		Up:          backfillUserVersionsUp,
+		Down:        backfillUserVersionsDown,
+	})
+}
+
+// backfillUserVersionsUp starts every user without a version at version 1, matching newly created users
+func backfillUserVersionsUp(ctx context.Context, env *Env) error {
+	filter := bson.M{"version": bson.M{"$exists": false}}
+	if env.DryRun {
+		count, err := env.Users.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		env.Logf("Would set version 1 on %d document(s)", count)
+		return nil
+	}
+
+	result, err := env.Users.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"version": 1}})
+	if err != nil {
+		return err
+	}
+	env.Logf("Set version 1 on %d document(s)", result.ModifiedCount)
+	return nil
+}
+
+func backfillUserVersionsDown(ctx context.Context, env *Env) error {
+	filter := bson.M{"version": bson.M{"$exists": true}}
+	if env.DryRun {
+		count, err := env.Users.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		env.Logf("Would remove the version from %d document(s)", count)
+		return nil
+	}
+
+	result, err := env.Users.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"version": "", "updatedat": ""}})
+	if err != nil {
+		return err
+	}
+	env.Logf("Removed the version from %d document(s)", result.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	register(Migration{
+		Version:     3,
+		Description: "Normalize stored emails and report the addresses used by more than one user",
+		Up:          normalizeEmailsUp,
+	})
+}
+
+// storedEmail is the part of a user document the email migration looks at
+type storedEmail struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	UserID string             `bson:"userid"`
+	Email  string             `bson:"email"`
+}
+
+// normalizeEmailsUp stores every email the way new users get it, trimmed and lowercased, so the unique email
+// index created by the repository can be built. Addresses that turn out to be used by more than one user would
+// make that index fail; they're logged and the migration fails without changing anything, so they can be
+// resolved by hand and the migration run again. It can't be rolled back, the original spelling is lost.
+func normalizeEmailsUp(ctx context.Context, env *Env) error {
+	filter := bson.M{"email": bson.M{"$gt": ""}}
+	cursor, err := env.Users.Find(ctx, filter, options.Find().SetProjection(bson.M{"userid": 1, "email": 1}))
+	if err != nil {
+		return err
+	}
+	var docs []storedEmail
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	// Group the users by their normalized email to find the ones sharing an address
+	byEmail := make(map[string][]storedEmail)
+	var changed []storedEmail
+	for _, doc := range docs {
+		normalized := models.NormalizeEmail(doc.Email)
+		byEmail[normalized] = append(byEmail[normalized], doc)
+		if normalized != doc.Email {
+			changed = append(changed, doc)
+		}
+	}
+
+	var duplicates []string
+	for email, users := range byEmail {
+		if email == "" || len(users) < 2 {
+			continue
+		}
+		ids := make([]string, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, fmt.Sprintf("%s (user id %q)", user.ID.Hex(), user.UserID))
+		}
+		duplicates = append(duplicates, fmt.Sprintf("%s: %s", email, strings.Join(ids, ", ")))
+	}
+	sort.Strings(duplicates)
+	for _, duplicate := range duplicates {
+		env.Logf("Email used by more than one user, %s", duplicate)
+	}
+
+	if env.DryRun {
+		env.Logf("Would normalize the email of %d document(s), %d email(s) are used by more than one user", len(changed), len(duplicates))
+		return nil
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%d email(s) are used by more than one user, give those users distinct emails and migrate again", len(duplicates))
+	}
+
+	for _, doc := range changed {
+		update := bson.M{"$set": bson.M{"email": models.NormalizeEmail(doc.Email)}}
+		if _, err := env.Users.UpdateByID(ctx, doc.ID, update); err != nil {
+			return fmt.Errorf("failed to normalize the email of %s: %w", doc.ID.Hex(), err)
+		}
+	}
+	env.Logf("Normalized the email of %d document(s)", len(changed))
+	return nil
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrIrreversible is returned when rolling back a migration that has no Down step
+var ErrIrreversible = errors.New("migration can't be rolled back")
+
+// Migration is a single, versioned change to the stored data
+type Migration struct {
+	// Version orders the migrations, it must be unique and should never change once released
+	Version     int
+	Description string
+	Up          func(ctx context.Context, env *Env) error
+	// Down reverts Up, leave it nil for migrations that can't be undone
+	Down func(ctx context.Context, env *Env) error
+}
+
+// Env is handed to every migration step
+type Env struct {
+	DB *mongo.Database
+	// Users is the collection holding the user documents
+	Users *mongo.Collection
+	// DryRun is set when the step should only report what it would change
+	DryRun bool
+}
+
+// Logf logs a message on behalf of a migration step, marking it when running dry
+func (e *Env) Logf(format string, args ...interface{}) {
+	if e.DryRun {
+		format = "[dry-run] " + format
+	}
+	log.Printf(format, args...)
+}
+
+var registry []Migration
+
+// register adds a migration to the list of known migrations, it's called from init() in the migration files
+func register(m Migration) {
+	for _, existing := range registry {
+		if existing.Version == m.Version {
+			panic(fmt.Sprintf("migrations: duplicate version %d", m.Version))
+		}
+	}
+	registry = append(registry, m)
+	sort.Slice(registry, func(i, j int) bool { return registry[i].Version < registry[j].Version })
+}
+
+// All returns the registered migrations ordered by version
+func All() []Migration {
+	return append([]Migration(nil), registry...)
+}
@@ -0,0 +1,289 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// historyCollection records which versions have been applied
+	historyCollection = "schema_migrations"
+	// lockCollection holds the lease that keeps two instances from migrating at the same time
+	lockCollection = "schema_migrations_lock"
+	lockID         = "lock"
+	lockLease      = 10 * time.Minute
+	// lockRenewInterval is how often the lease is extended while migrations run, well within the lease so a
+	// failed renewal can be retried
+	lockRenewInterval = 2 * time.Minute
+	// lockPollInterval is how often an instance waiting for the lock checks whether it was released
+	lockPollInterval = 5 * time.Second
+)
+
+// ErrLocked is returned when another instance is currently running migrations
+var ErrLocked = errors.New("migrations are locked by another instance")
+
+// ErrLockLost is returned when another instance took over the lock while migrations were running
+var ErrLockLost = errors.New("migration lock was taken over by another instance")
+
+// Record is the document stored for every applied migration
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedat"`
+	DurationMs  int64     `bson:"durationms"`
+}
+
+// Status describes a known migration and whether it has been applied
+type Status struct {
+	Migration Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Options tweak how migrations are applied or rolled back
+type Options struct {
+	// DryRun only reports what would change without writing anything
+	DryRun bool
+	// Target is the highest version to apply when migrating up, 0 means all
+	Target int
+	// Steps is the number of migrations to roll back when migrating down, 0 means 1
+	Steps int
+}
+
+// Migrator applies and rolls back the registered migrations against a database
+type Migrator struct {
+	db         *mongo.Database
+	users      *mongo.Collection
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the given database and user collection
+func NewMigrator(db *mongo.Database, userCollection string) *Migrator {
+	return &Migrator{
+		db:         db,
+		users:      db.Collection(userCollection),
+		migrations: All(),
+	}
+}
+
+// Status lists every known migration together with its applied state
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: record.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// Up applies all pending migrations in order, up to and including opts.Target when set
+func (m *Migrator) Up(ctx context.Context, opts Options) error {
+	return m.withLock(ctx, opts, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		env := m.env(opts)
+		for _, migration := range m.migrations {
+			if opts.Target > 0 && migration.Version > opts.Target {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			env.Logf("Applying migration %d: %s", migration.Version, migration.Description)
+			start := time.Now()
+			if err := migration.Up(ctx, env); err != nil {
+				return fmt.Errorf("migration %d failed: %w", migration.Version, err)
+			}
+			if opts.DryRun {
+				continue
+			}
+
+			record := Record{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   time.Now().UTC(),
+				DurationMs:  time.Since(start).Milliseconds(),
+			}
+			if _, err := m.db.Collection(historyCollection).InsertOne(ctx, record); err != nil {
+				return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+			}
+		}
+		return nil
+	})
+}
+
+// UpWhenUnlocked applies the pending migrations like Up. When another instance holds the lock, e.g. because
+// several instances start at once, it waits for that instance to finish and then applies whatever is left,
+// usually nothing. A lock that is never released expires after its lease. It gives up once ctx is done.
+func (m *Migrator) UpWhenUnlocked(ctx context.Context, opts Options) error {
+	for {
+		err := m.Up(ctx, opts)
+		if !errors.Is(err, ErrLocked) {
+			return err
+		}
+
+		log.Printf("Migrations are being run by another instance, checking again in %s", lockPollInterval)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the migration lock: %w", ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// Down rolls back the most recently applied migrations, opts.Steps at a time
+func (m *Migrator) Down(ctx context.Context, opts Options) error {
+	steps := opts.Steps
+	if steps <= 0 {
+		steps = 1
+	}
+
+	return m.withLock(ctx, opts, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		env := m.env(opts)
+		// Walk backwards over the known migrations so the newest applied one is reverted first
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d: %w", migration.Version, ErrIrreversible)
+			}
+
+			env.Logf("Rolling back migration %d: %s", migration.Version, migration.Description)
+			if err := migration.Down(ctx, env); err != nil {
+				return fmt.Errorf("rollback of migration %d failed: %w", migration.Version, err)
+			}
+			steps--
+			if opts.DryRun {
+				continue
+			}
+
+			if _, err := m.db.Collection(historyCollection).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+				return fmt.Errorf("failed to remove record of migration %d: %w", migration.Version, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) env(opts Options) *Env {
+	return &Env{DB: m.db, Users: m.users, DryRun: opts.DryRun}
+}
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := m.db.Collection(historyCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration history: %w", err)
+	}
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode migration history: %w", err)
+	}
+
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// withLock runs fn while holding the migration lease, dry runs don't take the lock since they don't write. The
+// lease is renewed while fn runs, so slow migrations keep it; the context given to fn is cancelled when another
+// instance took the lock over anyway.
+func (m *Migrator) withLock(ctx context.Context, opts Options, fn func(ctx context.Context) error) error {
+	if opts.DryRun {
+		return fn(ctx)
+	}
+
+	// Every run has an owner of its own, since containers can share a hostname and a restarted pod reuses its
+	// own. The hostname only tells where the lock is held.
+	hostname, _ := os.Hostname()
+	owner := hostname + "/" + primitive.NewObjectID().Hex()
+	now := time.Now()
+	locks := m.db.Collection(lockCollection)
+
+	// Take the lease when it doesn't exist or has expired, the upsert fails with a duplicate key otherwise
+	filter := bson.M{"_id": lockID, "lockeduntil": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"owner": owner, "lockeduntil": now.Add(lockLease)}}
+	if _, err := locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrLocked
+		}
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	renewing := make(chan struct{})
+	go func() {
+		defer close(renewing)
+		renewLock(lockCtx, locks, owner, cancel)
+	}()
+	defer func() {
+		cancel(nil)
+		<-renewing
+		if _, err := locks.DeleteOne(context.Background(), bson.M{"_id": lockID, "owner": owner}); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+	}()
+
+	err := fn(lockCtx)
+	if err != nil && errors.Is(context.Cause(lockCtx), ErrLockLost) {
+		return fmt.Errorf("%w: %v", ErrLockLost, err)
+	}
+	return err
+}
+
+// renewLock extends the lease of owner every lockRenewInterval until ctx is done. A failed renewal is retried,
+// the lease still runs for a while. When another instance holds the lock, it cancels ctx with ErrLockLost.
+func renewLock(ctx context.Context, locks *mongo.Collection, owner string, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		update := bson.M{"$set": bson.M{"lockeduntil": time.Now().Add(lockLease)}}
+		result, err := locks.UpdateOne(ctx, bson.M{"_id": lockID, "owner": owner}, update)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to renew migration lock, retrying in %s: %v", lockRenewInterval, err)
+			}
+			continue
+		}
+		if result.MatchedCount == 0 {
+			log.Printf("Migration lock was taken over by another instance, stopping")
+			cancel(ErrLockLost)
+			return
+		}
+	}
+}
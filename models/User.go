@@ -1,15 +1,37 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type User struct {
-	ID               primitive.ObjectID `bson:"_id,omitempty"`
-	Email            string             `bson:"email,omitempty"`
-	Phone            string             `bson:"phone,omitempty"`
-	DateOfBirth      string             `bson:"dateofbirth,omitempty"`
-	FirstName        string             `bson:"firstname,omitempty"`
-	LastName         string             `bson:"lastname,omitempty"`
-	CreditCardNumber int32              `bson:"creditcardnumber,omitempty"`
-	ExpirationDate   string             `bson:"expirationdate,omitempty"`
-	CVC              int32              `bson:"cvc,omitempty"`
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	UserID           string             `bson:"userid,omitempty" json:"userid,omitempty"`
+	Email            string             `bson:"email,omitempty" json:"email,omitempty"`
+	Phone            string             `bson:"phone,omitempty" json:"phone,omitempty"`
+	DateOfBirth      string             `bson:"dateofbirth,omitempty" json:"dateofbirth,omitempty"`
+	FirstName        string             `bson:"firstname,omitempty" json:"firstname,omitempty"`
+	LastName         string             `bson:"lastname,omitempty" json:"lastname,omitempty"`
+	CreditCardNumber int32              `bson:"creditcardnumber,omitempty" json:"creditcardnumber,omitempty"`
+	ExpirationDate   string             `bson:"expirationdate,omitempty" json:"expirationdate,omitempty"`
+	CVC              int32              `bson:"cvc,omitempty" json:"cvc,omitempty"`
+	// Version is incremented on every update so concurrent writers can detect each other
+	Version   int64     `bson:"version" json:"version"`
+	UpdatedAt time.Time `bson:"updatedat,omitempty" json:"updatedat,omitempty"`
+	// DeletedAt is set when the user is pending deletion, the user is erased for good after PurgeAfter
+	DeletedAt  time.Time `bson:"deletedat,omitempty" json:"deletedat,omitempty"`
+	PurgeAfter time.Time `bson:"purgeafter,omitempty" json:"purgeafter,omitempty"`
+}
+
+// IsDeleted reports whether the user is pending deletion
+func (u *User) IsDeleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// NormalizeEmail trims surrounding whitespace and lowercases the address so uniqueness checks are case-insensitive
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
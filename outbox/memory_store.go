@@ -0,0 +1,106 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps the outbox in memory, it's meant for tests and local development
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewMemoryStore creates an empty in-memory outbox
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, messages ...*Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range prepare(messages) {
+		stored := *message
+		s.messages = append(s.messages, &stored)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Pending(ctx context.Context, owner string, now, until time.Time, limit int) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocked := make(map[string]bool)
+	for _, message := range s.messages {
+		if message.Status == StatusPending && (message.NextAttemptAt.After(now) || claimedByOther(message, owner, now)) {
+			blocked[message.Key] = true
+		}
+	}
+
+	// Messages are appended in order, so the slice is already sorted oldest first. Holding the lock makes
+	// the claims atomic.
+	var pending []*Message
+	for _, message := range s.messages {
+		if message.Status != StatusPending || message.NextAttemptAt.After(now) || blocked[message.Key] {
+			continue
+		}
+		message.Owner, message.LockedUntil = owner, until
+		copied := *message
+		pending = append(pending, &copied)
+		if limit > 0 && len(pending) == limit {
+			break
+		}
+	}
+	return pending, nil
+}
+
+func (s *MemoryStore) MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return s.update(id, func(message *Message) {
+		message.Status = StatusPublished
+		message.PublishedAt = at
+	})
+}
+
+func (s *MemoryStore) MarkFailed(ctx context.Context, id primitive.ObjectID, reason string, nextAttemptAt time.Time) error {
+	return s.update(id, func(message *Message) {
+		message.Attempts++
+		message.LastError = reason
+		message.NextAttemptAt = nextAttemptAt
+		message.Owner, message.LockedUntil = "", time.Time{}
+	})
+}
+
+func (s *MemoryStore) Release(ctx context.Context, owner string, ids []primitive.ObjectID) error {
+	for _, id := range ids {
+		s.update(id, func(message *Message) {
+			if message.Owner == owner {
+				message.Owner, message.LockedUntil = "", time.Time{}
+			}
+		})
+	}
+	return nil
+}
+
+func (s *MemoryStore) update(id primitive.ObjectID, fn func(*Message)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range s.messages {
+		if message.ID == id {
+			fn(message)
+			return nil
+		}
+	}
+	return nil
+}
+
+// claimedByOther tells whether another owner is still publishing the message
+func claimedByOther(message *Message, owner string, now time.Time) bool {
+	return message.Owner != owner && message.LockedUntil.After(now)
+}
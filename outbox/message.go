@@ -0,0 +1,40 @@
+package outbox
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Message statuses
+const (
+	StatusPending   = "pending"
+	StatusPublished = "published"
+)
+
+// Message is an event waiting to be published to RabbitMQ. It's written in the same transaction as the change
+// it describes, so the event is published if and only if the change is committed.
+type Message struct {
+	ID primitive.ObjectID `bson:"_id,omitempty"`
+	// Key orders the messages, messages with the same key (the user id) are published in the order they were added
+	Key        string `bson:"key"`
+	Exchange   string `bson:"exchange"`
+	RoutingKey string `bson:"routingkey"`
+	// ContentType describes Payload, which is published as the message body
+	ContentType string `bson:"contenttype"`
+	Payload     []byte `bson:"payload"`
+	// ReplyTo and CorrelationID are set on requests that expect an answer
+	ReplyTo       string    `bson:"replyto,omitempty"`
+	CorrelationID string    `bson:"correlationid,omitempty"`
+	Status        string    `bson:"status"`
+	CreatedAt     time.Time `bson:"createdat"`
+	// Attempts counts failed publishes, the next attempt isn't made before NextAttemptAt
+	Attempts      int       `bson:"attempts"`
+	NextAttemptAt time.Time `bson:"nextattemptat"`
+	LastError     string    `bson:"lasterror,omitempty"`
+	PublishedAt   time.Time `bson:"publishedat,omitempty"`
+	// Owner is the relay that claimed the message to publish it, other relays leave the message and the later
+	// messages of its key alone until LockedUntil
+	Owner       string    `bson:"owner,omitempty"`
+	LockedUntil time.Time `bson:"lockeduntil,omitempty"`
+}
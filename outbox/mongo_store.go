@@ -0,0 +1,160 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// publishedRetention is how long published messages are kept around before MongoDB removes them
+const publishedRetention = 7 * 24 * time.Hour
+
+var _ Store = (*MongoStore)(nil)
+
+// MongoStore keeps the outbox in a MongoDB collection
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoStore creates an outbox backed by the given collection
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+// EnsureIndexes creates the indexes used to find due messages and the keys waiting for a retry, and the TTL
+// index cleaning up published ones
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}, {Key: "createdat", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}, {Key: "key", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "publishedat", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(publishedRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+func (s *MongoStore) Add(ctx context.Context, messages ...*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(messages))
+	for _, message := range prepare(messages) {
+		docs = append(docs, message)
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *MongoStore) Pending(ctx context.Context, owner string, now, until time.Time, limit int) ([]*Message, error) {
+	// Only failed messages are ever due later and claims only last while a batch is published, so there are
+	// few keys held back
+	blocked, err := s.collection.Distinct(ctx, "key", bson.M{
+		"status": StatusPending,
+		"$or": bson.A{
+			bson.M{"nextattemptat": bson.M{"$gt": now}},
+			claimedByOtherFilter(owner, now),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"status": StatusPending, "nextattemptat": bson.M{"$lte": now}}
+	if len(blocked) > 0 {
+		filter["key"] = bson.M{"$nin": blocked}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*Message
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	// Claim the messages one by one in order. Another relay that read the same messages can only claim each of
+	// them once, and whoever loses a message leaves the rest of its key alone, so a key is never published by
+	// two relays at once.
+	lost := make(map[string]bool)
+	var claimed []*Message
+	for _, message := range candidates {
+		if lost[message.Key] {
+			continue
+		}
+		result, err := s.collection.UpdateOne(ctx, bson.M{
+			"_id":           message.ID,
+			"status":        StatusPending,
+			"nextattemptat": bson.M{"$lte": now},
+			"$nor":          bson.A{claimedByOtherFilter(owner, now)},
+		}, bson.M{"$set": bson.M{"owner": owner, "lockeduntil": until}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			lost[message.Key] = true
+			continue
+		}
+		message.Owner, message.LockedUntil = owner, until
+		claimed = append(claimed, message)
+	}
+	return claimed, nil
+}
+
+func (s *MongoStore) MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	update := bson.M{"$set": bson.M{"status": StatusPublished, "publishedat": at}}
+	_, err := s.collection.UpdateByID(ctx, id, update)
+	return err
+}
+
+func (s *MongoStore) MarkFailed(ctx context.Context, id primitive.ObjectID, reason string, nextAttemptAt time.Time) error {
+	update := bson.M{
+		"$set":   bson.M{"lasterror": reason, "nextattemptat": nextAttemptAt},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"owner": "", "lockeduntil": ""},
+	}
+	_, err := s.collection.UpdateByID(ctx, id, update)
+	return err
+}
+
+func (s *MongoStore) Release(ctx context.Context, owner string, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	filter := bson.M{"_id": bson.M{"$in": ids}, "owner": owner}
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"owner": "", "lockeduntil": ""}})
+	return err
+}
+
+// claimedByOtherFilter matches the messages another owner is still publishing
+func claimedByOtherFilter(owner string, now time.Time) bson.M {
+	return bson.M{"lockeduntil": bson.M{"$gt": now}, "owner": bson.M{"$ne": owner}}
+}
+
+// prepare fills in the defaults of new messages
+func prepare(messages []*Message) []*Message {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, message := range messages {
+		if message.ID.IsZero() {
+			message.ID = primitive.NewObjectID()
+		}
+		if message.CreatedAt.IsZero() {
+			message.CreatedAt = now
+		}
+		if message.ContentType == "" {
+			message.ContentType = "application/json"
+		}
+		message.Status = StatusPending
+		message.NextAttemptAt = message.CreatedAt
+	}
+	return messages
+}
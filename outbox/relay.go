@@ -0,0 +1,123 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	relayBatchSize = 100
+	// A batch is claimed for claimDuration, other relays leave its messages alone until then. Publishing stops
+	// claimMargin before the claim runs out, so no message is published while another relay may take it over.
+	claimDuration = 5 * time.Minute
+	claimMargin   = time.Minute
+	// Failed publishes are retried with exponential backoff between retryBaseDelay and retryMaxDelay
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// Publisher delivers an outbox message to the broker, returning an error when it may not have arrived
+type Publisher interface {
+	Publish(ctx context.Context, message *Message) error
+}
+
+// Relay publishes pending outbox messages. Messages are marked published only after the broker accepted them,
+// so delivery is at-least-once: a crash in between publishes the message again. Every instance of the service
+// runs a relay, they claim the messages they publish so a message isn't published by more than one of them.
+type Relay struct {
+	store     Store
+	publisher Publisher
+	interval  time.Duration
+	// owner tells the claims of this relay apart from those of the relays of other instances
+	owner string
+}
+
+// NewRelay creates a relay that looks for pending messages every interval
+func NewRelay(store Store, publisher Publisher, interval time.Duration) *Relay {
+	return &Relay{store: store, publisher: publisher, interval: interval, owner: primitive.NewObjectID().Hex()}
+}
+
+// Run relays messages until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.RelayPending(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Relaying outbox messages failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending claims and publishes a batch of due messages. Once a message of a key fails, the later messages
+// of that key are held back until it's published, so every key is published in order.
+func (r *Relay) RelayPending(ctx context.Context) error {
+	now := time.Now().UTC()
+	until := now.Add(claimDuration)
+	messages, err := r.store.Pending(ctx, r.owner, now, until, relayBatchSize)
+	if err != nil {
+		return err
+	}
+
+	// The messages left over, because of an earlier failure of their key or an error, are handed back at the
+	// end so other relays don't have to wait for the claim to run out
+	handled := make(map[primitive.ObjectID]bool)
+	defer func() {
+		var leftOver []primitive.ObjectID
+		for _, message := range messages {
+			if !handled[message.ID] {
+				leftOver = append(leftOver, message.ID)
+			}
+		}
+		if err := r.store.Release(context.Background(), r.owner, leftOver); err != nil {
+			log.Printf("Releasing %d outbox message(s) failed: %v", len(leftOver), err)
+		}
+	}()
+
+	blocked := make(map[string]bool)
+	for i, message := range messages {
+		if blocked[message.Key] {
+			continue
+		}
+		if time.Now().After(until.Add(-claimMargin)) {
+			log.Printf("The claim on %d outbox message(s) is about to run out, leaving them for the next batch", len(messages)-i)
+			return nil
+		}
+
+		if err := r.publisher.Publish(ctx, message); err != nil {
+			blocked[message.Key] = true
+			next := now.Add(backoff(message.Attempts))
+			log.Printf("Publishing outbox message %s (attempt %d) failed, retrying at %s: %v", message.ID.Hex(), message.Attempts+1, next.Format(time.RFC3339), err)
+			if err := r.store.MarkFailed(ctx, message.ID, err.Error(), next); err != nil {
+				return err
+			}
+			handled[message.ID] = true
+			continue
+		}
+
+		if err := r.store.MarkPublished(ctx, message.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+		handled[message.ID] = true
+	}
+	return nil
+}
+
+// backoff returns the delay before the next attempt after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	delay := time.Duration(float64(retryBaseDelay) * math.Pow(2, float64(attempts)))
+	if delay <= 0 || delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
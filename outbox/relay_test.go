@@ -0,0 +1,107 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordingPublisher remembers the routing keys of the messages it published per key
+type recordingPublisher struct {
+	mu        sync.Mutex
+	published map[string][]string
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, message *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.published == nil {
+		p.published = make(map[string][]string)
+	}
+	p.published[message.Key] = append(p.published[message.Key], message.RoutingKey)
+	return nil
+}
+
+func TestRelaysPublishEveryMessageOnce(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	const keys, perKey = 10, 20
+	for i := 0; i < perKey; i++ {
+		for key := 0; key < keys; key++ {
+			message := &Message{Key: fmt.Sprintf("user-%d", key), RoutingKey: fmt.Sprintf("%03d", i)}
+			if err := store.Add(ctx, message); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+		}
+	}
+
+	// Several instances of the service relay the same outbox at the same time
+	publisher := &recordingPublisher{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		relay := NewRelay(store, publisher, time.Minute)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < keys*perKey; j++ {
+				if err := relay.RelayPending(ctx); err != nil {
+					t.Errorf("RelayPending: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for key := 0; key < keys; key++ {
+		published := publisher.published[fmt.Sprintf("user-%d", key)]
+		if len(published) != perKey {
+			t.Errorf("Published %d message(s) of user-%d, want %d", len(published), key, perKey)
+			continue
+		}
+		for i, routingKey := range published {
+			if routingKey != fmt.Sprintf("%03d", i) {
+				t.Errorf("Messages of user-%d were published in the order %v", key, published)
+				break
+			}
+		}
+	}
+}
+
+func TestMemoryStoreLeavesClaimedKeysAlone(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := store.Add(ctx, &Message{Key: "user-1"}, &Message{Key: "user-1"}, &Message{Key: "user-2"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	now := time.Now().UTC()
+
+	first, err := store.Pending(ctx, "relay-1", now, now.Add(time.Minute), 1)
+	if err != nil || len(first) != 1 || first[0].Key != "user-1" {
+		t.Fatalf("Pending returned %v, %v; want the first message of user-1", first, err)
+	}
+	// The second message of user-1 mustn't overtake the first one, which relay-1 is publishing
+	second, err := store.Pending(ctx, "relay-2", now, now.Add(time.Minute), 10)
+	if err != nil || len(second) != 1 || second[0].Key != "user-2" {
+		t.Fatalf("Pending of another relay returned %v, %v; want only the message of user-2", second, err)
+	}
+
+	// Once relay-1 gives up its claim, another relay can publish user-1
+	if err := store.Release(ctx, "relay-1", []primitive.ObjectID{first[0].ID}); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	third, err := store.Pending(ctx, "relay-3", now, now.Add(time.Minute), 10)
+	if err != nil || len(third) != 2 || third[0].ID != first[0].ID {
+		t.Errorf("Pending after releasing returned %v, %v; want both messages of user-1", third, err)
+	}
+
+	// And a claim that ran out is up for grabs again
+	later := now.Add(2 * time.Minute)
+	if expired, err := store.Pending(ctx, "relay-1", later, later.Add(time.Minute), 10); err != nil || len(expired) != 3 {
+		t.Errorf("Pending after the claims ran out returned %v, %v; want all messages", expired, err)
+	}
+}
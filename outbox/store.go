@@ -0,0 +1,25 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store keeps the outbox messages
+type Store interface {
+	// Add stores new pending messages, use the context of a transaction to add them along with a change
+	Add(ctx context.Context, messages ...*Message) error
+	// Pending claims up to limit pending messages that are due at now for owner until the given time, and returns
+	// them oldest first. Keys that have a message waiting for another attempt or claimed by another owner are left
+	// out, so their later messages don't overtake it. A message claimed by someone else in the meantime leaves
+	// out the rest of its key as well.
+	Pending(ctx context.Context, owner string, now, until time.Time, limit int) ([]*Message, error)
+	// MarkPublished records that the message reached the broker
+	MarkPublished(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// MarkFailed records a failed attempt and when to try again, and gives up the claim on the message
+	MarkFailed(ctx context.Context, id primitive.ObjectID, reason string, nextAttemptAt time.Time) error
+	// Release gives up the claims of owner on the messages, so other owners can publish them
+	Release(ctx context.Context, owner string, ids []primitive.ObjectID) error
+}
@@ -19,12 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	UserService_CreateUser_FullMethodName     = "/user.UserService/CreateUser"
-	UserService_ReadUser_FullMethodName       = "/user.UserService/ReadUser"
-	UserService_UpdateUser_FullMethodName     = "/user.UserService/UpdateUser"
-	UserService_DeleteUser_FullMethodName     = "/user.UserService/DeleteUser"
-	UserService_ListUsers_FullMethodName      = "/user.UserService/ListUsers"
-	UserService_GetAllUserData_FullMethodName = "/user.UserService/GetAllUserData"
+	UserService_CreateUser_FullMethodName          = "/user.UserService/CreateUser"
+	UserService_ReadUser_FullMethodName            = "/user.UserService/ReadUser"
+	UserService_UpdateUser_FullMethodName          = "/user.UserService/UpdateUser"
+	UserService_DeleteUser_FullMethodName          = "/user.UserService/DeleteUser"
+	UserService_RestoreUser_FullMethodName         = "/user.UserService/RestoreUser"
+	UserService_ListUsers_FullMethodName           = "/user.UserService/ListUsers"
+	UserService_GetAllUserData_FullMethodName      = "/user.UserService/GetAllUserData"
+	UserService_ListUserAuditEvents_FullMethodName = "/user.UserService/ListUserAuditEvents"
+	UserService_RequestDataExport_FullMethodName   = "/user.UserService/RequestDataExport"
+	UserService_GetDataExportStatus_FullMethodName = "/user.UserService/GetDataExportStatus"
+	UserService_DownloadDataExport_FullMethodName  = "/user.UserService/DownloadDataExport"
+	UserService_GetDeletionStatus_FullMethodName   = "/user.UserService/GetDeletionStatus"
 )
 
 // UserServiceClient is the client API for UserService service.
@@ -35,8 +41,14 @@ type UserServiceClient interface {
 	ReadUser(ctx context.Context, in *ReadUserReq, opts ...grpc.CallOption) (*ReadUserRes, error)
 	UpdateUser(ctx context.Context, in *UpdateUserReq, opts ...grpc.CallOption) (*UpdateUserRes, error)
 	DeleteUser(ctx context.Context, in *DeleteUserReq, opts ...grpc.CallOption) (*DeleteUserRes, error)
+	RestoreUser(ctx context.Context, in *RestoreUserReq, opts ...grpc.CallOption) (*RestoreUserRes, error)
 	ListUsers(ctx context.Context, in *ListUsersReq, opts ...grpc.CallOption) (UserService_ListUsersClient, error)
 	GetAllUserData(ctx context.Context, in *GetAllUserDataReq, opts ...grpc.CallOption) (*GetAllUserDataRes, error)
+	ListUserAuditEvents(ctx context.Context, in *ListUserAuditEventsReq, opts ...grpc.CallOption) (UserService_ListUserAuditEventsClient, error)
+	RequestDataExport(ctx context.Context, in *RequestDataExportReq, opts ...grpc.CallOption) (*RequestDataExportRes, error)
+	GetDataExportStatus(ctx context.Context, in *GetDataExportStatusReq, opts ...grpc.CallOption) (*GetDataExportStatusRes, error)
+	DownloadDataExport(ctx context.Context, in *DownloadDataExportReq, opts ...grpc.CallOption) (UserService_DownloadDataExportClient, error)
+	GetDeletionStatus(ctx context.Context, in *GetDeletionStatusReq, opts ...grpc.CallOption) (*GetDeletionStatusRes, error)
 }
 
 type userServiceClient struct {
@@ -83,6 +95,15 @@ func (c *userServiceClient) DeleteUser(ctx context.Context, in *DeleteUserReq, o
 	return out, nil
 }
 
+func (c *userServiceClient) RestoreUser(ctx context.Context, in *RestoreUserReq, opts ...grpc.CallOption) (*RestoreUserRes, error) {
+	out := new(RestoreUserRes)
+	err := c.cc.Invoke(ctx, UserService_RestoreUser_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *userServiceClient) ListUsers(ctx context.Context, in *ListUsersReq, opts ...grpc.CallOption) (UserService_ListUsersClient, error) {
 	stream, err := c.cc.NewStream(ctx, &UserService_ServiceDesc.Streams[0], UserService_ListUsers_FullMethodName, opts...)
 	if err != nil {
@@ -124,6 +145,97 @@ func (c *userServiceClient) GetAllUserData(ctx context.Context, in *GetAllUserDa
 	return out, nil
 }
 
+func (c *userServiceClient) ListUserAuditEvents(ctx context.Context, in *ListUserAuditEventsReq, opts ...grpc.CallOption) (UserService_ListUserAuditEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserService_ServiceDesc.Streams[1], UserService_ListUserAuditEvents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceListUserAuditEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserService_ListUserAuditEventsClient interface {
+	Recv() (*ListUserAuditEventsRes, error)
+	grpc.ClientStream
+}
+
+type userServiceListUserAuditEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *userServiceListUserAuditEventsClient) Recv() (*ListUserAuditEventsRes, error) {
+	m := new(ListUserAuditEventsRes)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userServiceClient) RequestDataExport(ctx context.Context, in *RequestDataExportReq, opts ...grpc.CallOption) (*RequestDataExportRes, error) {
+	out := new(RequestDataExportRes)
+	err := c.cc.Invoke(ctx, UserService_RequestDataExport_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) GetDataExportStatus(ctx context.Context, in *GetDataExportStatusReq, opts ...grpc.CallOption) (*GetDataExportStatusRes, error) {
+	out := new(GetDataExportStatusRes)
+	err := c.cc.Invoke(ctx, UserService_GetDataExportStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) DownloadDataExport(ctx context.Context, in *DownloadDataExportReq, opts ...grpc.CallOption) (UserService_DownloadDataExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserService_ServiceDesc.Streams[2], UserService_DownloadDataExport_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceDownloadDataExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserService_DownloadDataExportClient interface {
+	Recv() (*DownloadDataExportRes, error)
+	grpc.ClientStream
+}
+
+type userServiceDownloadDataExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *userServiceDownloadDataExportClient) Recv() (*DownloadDataExportRes, error) {
+	m := new(DownloadDataExportRes)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userServiceClient) GetDeletionStatus(ctx context.Context, in *GetDeletionStatusReq, opts ...grpc.CallOption) (*GetDeletionStatusRes, error) {
+	out := new(GetDeletionStatusRes)
+	err := c.cc.Invoke(ctx, UserService_GetDeletionStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UserServiceServer is the server API for UserService service.
 // All implementations must embed UnimplementedUserServiceServer
 // for forward compatibility
@@ -132,8 +244,14 @@ type UserServiceServer interface {
 	ReadUser(context.Context, *ReadUserReq) (*ReadUserRes, error)
 	UpdateUser(context.Context, *UpdateUserReq) (*UpdateUserRes, error)
 	DeleteUser(context.Context, *DeleteUserReq) (*DeleteUserRes, error)
+	RestoreUser(context.Context, *RestoreUserReq) (*RestoreUserRes, error)
 	ListUsers(*ListUsersReq, UserService_ListUsersServer) error
 	GetAllUserData(context.Context, *GetAllUserDataReq) (*GetAllUserDataRes, error)
+	ListUserAuditEvents(*ListUserAuditEventsReq, UserService_ListUserAuditEventsServer) error
+	RequestDataExport(context.Context, *RequestDataExportReq) (*RequestDataExportRes, error)
+	GetDataExportStatus(context.Context, *GetDataExportStatusReq) (*GetDataExportStatusRes, error)
+	DownloadDataExport(*DownloadDataExportReq, UserService_DownloadDataExportServer) error
+	GetDeletionStatus(context.Context, *GetDeletionStatusReq) (*GetDeletionStatusRes, error)
 	mustEmbedUnimplementedUserServiceServer()
 }
 
@@ -153,12 +271,30 @@ func (UnimplementedUserServiceServer) UpdateUser(context.Context, *UpdateUserReq
 func (UnimplementedUserServiceServer) DeleteUser(context.Context, *DeleteUserReq) (*DeleteUserRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
 }
+func (UnimplementedUserServiceServer) RestoreUser(context.Context, *RestoreUserReq) (*RestoreUserRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreUser not implemented")
+}
 func (UnimplementedUserServiceServer) ListUsers(*ListUsersReq, UserService_ListUsersServer) error {
 	return status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
 func (UnimplementedUserServiceServer) GetAllUserData(context.Context, *GetAllUserDataReq) (*GetAllUserDataRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAllUserData not implemented")
 }
+func (UnimplementedUserServiceServer) ListUserAuditEvents(*ListUserAuditEventsReq, UserService_ListUserAuditEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListUserAuditEvents not implemented")
+}
+func (UnimplementedUserServiceServer) RequestDataExport(context.Context, *RequestDataExportReq) (*RequestDataExportRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestDataExport not implemented")
+}
+func (UnimplementedUserServiceServer) GetDataExportStatus(context.Context, *GetDataExportStatusReq) (*GetDataExportStatusRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDataExportStatus not implemented")
+}
+func (UnimplementedUserServiceServer) DownloadDataExport(*DownloadDataExportReq, UserService_DownloadDataExportServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadDataExport not implemented")
+}
+func (UnimplementedUserServiceServer) GetDeletionStatus(context.Context, *GetDeletionStatusReq) (*GetDeletionStatusRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeletionStatus not implemented")
+}
 func (UnimplementedUserServiceServer) mustEmbedUnimplementedUserServiceServer() {}
 
 // UnsafeUserServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -244,6 +380,24 @@ func _UserService_DeleteUser_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserService_RestoreUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreUserReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).RestoreUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_RestoreUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).RestoreUser(ctx, req.(*RestoreUserReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _UserService_ListUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(ListUsersReq)
 	if err := stream.RecvMsg(m); err != nil {
@@ -283,6 +437,102 @@ func _UserService_GetAllUserData_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserService_ListUserAuditEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListUserAuditEventsReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServiceServer).ListUserAuditEvents(m, &userServiceListUserAuditEventsServer{stream})
+}
+
+type UserService_ListUserAuditEventsServer interface {
+	Send(*ListUserAuditEventsRes) error
+	grpc.ServerStream
+}
+
+type userServiceListUserAuditEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *userServiceListUserAuditEventsServer) Send(m *ListUserAuditEventsRes) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UserService_RequestDataExport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestDataExportReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).RequestDataExport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_RequestDataExport_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).RequestDataExport(ctx, req.(*RequestDataExportReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_GetDataExportStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDataExportStatusReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetDataExportStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetDataExportStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetDataExportStatus(ctx, req.(*GetDataExportStatusReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_DownloadDataExport_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadDataExportReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServiceServer).DownloadDataExport(m, &userServiceDownloadDataExportServer{stream})
+}
+
+type UserService_DownloadDataExportServer interface {
+	Send(*DownloadDataExportRes) error
+	grpc.ServerStream
+}
+
+type userServiceDownloadDataExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *userServiceDownloadDataExportServer) Send(m *DownloadDataExportRes) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UserService_GetDeletionStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeletionStatusReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetDeletionStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetDeletionStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetDeletionStatus(ctx, req.(*GetDeletionStatusReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // UserService_ServiceDesc is the grpc.ServiceDesc for UserService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -306,10 +556,26 @@ var UserService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteUser",
 			Handler:    _UserService_DeleteUser_Handler,
 		},
+		{
+			MethodName: "RestoreUser",
+			Handler:    _UserService_RestoreUser_Handler,
+		},
 		{
 			MethodName: "GetAllUserData",
 			Handler:    _UserService_GetAllUserData_Handler,
 		},
+		{
+			MethodName: "RequestDataExport",
+			Handler:    _UserService_RequestDataExport_Handler,
+		},
+		{
+			MethodName: "GetDataExportStatus",
+			Handler:    _UserService_GetDataExportStatus_Handler,
+		},
+		{
+			MethodName: "GetDeletionStatus",
+			Handler:    _UserService_GetDeletionStatus_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -317,6 +583,16 @@ var UserService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _UserService_ListUsers_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ListUserAuditEvents",
+			Handler:       _UserService_ListUserAuditEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DownloadDataExport",
+			Handler:       _UserService_DownloadDataExport_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/user.proto",
 }
@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ UserRepository = (*MemoryUserRepository)(nil)
+
+// MemoryUserRepository keeps users in memory, it's meant for tests and local development
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]models.User
+	// order keeps track of insertion order so List behaves like a collection scan
+	order []primitive.ObjectID
+}
+
+// NewMemoryUserRepository creates an empty in-memory repository
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[primitive.ObjectID]models.User)}
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.Version = 1
+	user.UpdatedAt = now()
+	r.users[user.ID] = *user
+	r.order = append(r.order, user.ID)
+	return nil
+}
+
+func (r *MemoryUserRepository) FindByID(ctx context.Context, id primitive.ObjectID, visibility Visibility) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok || !isVisible(&user, visibility) {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByUserID(ctx context.Context, userID string, visibility Visibility) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, id := range r.order {
+		if user := r.users[id]; hasUserID(&user, userID) && isVisible(&user, visibility) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *models.User, expectedVersion int64) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.ID]
+	if !ok || stored.IsDeleted() {
+		return nil, ErrNotFound
+	}
+	if stored.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	if r.emailTaken(user.Email, user.ID) {
+		return nil, ErrDuplicateEmail
+	}
+	// Only the editable fields are overwritten, the external user id is kept
+	stored.Email = user.Email
+	stored.Phone = user.Phone
+	stored.DateOfBirth = user.DateOfBirth
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.CreditCardNumber = user.CreditCardNumber
+	stored.ExpirationDate = user.ExpirationDate
+	stored.CVC = user.CVC
+	stored.Version = expectedVersion + 1
+	stored.UpdatedAt = now()
+	r.users[user.ID] = stored
+	return &stored, nil
+}
+
+func (r *MemoryUserRepository) SoftDeleteByUserID(ctx context.Context, userID string, deletedAt, purgeAfter time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var marked int64
+	for id, user := range r.users {
+		if !hasUserID(&user, userID) || user.IsDeleted() {
+			continue
+		}
+		user.DeletedAt = deletedAt
+		user.PurgeAfter = purgeAfter
+		user.Version++
+		user.UpdatedAt = now()
+		r.users[id] = user
+		marked++
+	}
+	return marked, nil
+}
+
+func (r *MemoryUserRepository) RestoreByUserID(ctx context.Context, userID string, at time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var restored int64
+	for id, user := range r.users {
+		if !hasUserID(&user, userID) || !user.IsDeleted() || !user.PurgeAfter.After(at) {
+			continue
+		}
+		user.DeletedAt = time.Time{}
+		user.PurgeAfter = time.Time{}
+		user.Version++
+		user.UpdatedAt = now()
+		r.users[id] = user
+		restored++
+	}
+	return restored, nil
+}
+
+func (r *MemoryUserRepository) ListPurgeable(ctx context.Context, at time.Time, limit int) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var purgeable []models.User
+	for _, id := range r.order {
+		if user := r.users[id]; user.IsDeleted() && !user.PurgeAfter.After(at) {
+			purgeable = append(purgeable, user)
+		}
+	}
+	sort.SliceStable(purgeable, func(i, j int) bool { return purgeable[i].PurgeAfter.Before(purgeable[j].PurgeAfter) })
+	if limit > 0 && len(purgeable) > limit {
+		purgeable = purgeable[:limit]
+	}
+	return distinctUserIDs(purgeable), nil
+}
+
+func (r *MemoryUserRepository) PurgeByUserID(ctx context.Context, userID string, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	remaining := r.order[:0]
+	for _, id := range r.order {
+		if user := r.users[id]; hasUserID(&user, userID) && user.IsDeleted() && !user.PurgeAfter.After(now) {
+			delete(r.users, id)
+			deleted++
+			continue
+		}
+		remaining = append(remaining, id)
+	}
+	r.order = remaining
+	return deleted, nil
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, visibility Visibility, fn func(*models.User) error) error {
+	// Take a snapshot so fn can safely call back into the repository
+	r.mu.RLock()
+	users := make([]models.User, 0, len(r.order))
+	for _, id := range r.order {
+		if user := r.users[id]; isVisible(&user, visibility) {
+			users = append(users, user)
+		}
+	}
+	r.mu.RUnlock()
+
+	for i := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emailTaken reports whether a user other than self already uses the email, the caller must hold the lock
+func (r *MemoryUserRepository) emailTaken(email string, self primitive.ObjectID) bool {
+	if email == "" {
+		return false
+	}
+	for id, user := range r.users {
+		if id != self && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUserID reports whether the user has the given user id. Like in MongoDB, which doesn't store an empty
+// userid, an empty id matches no user, not even the users created without one.
+func hasUserID(user *models.User, userID string) bool {
+	return userID != "" && user.UserID == userID
+}
+
+// isVisible is the in-memory counterpart of the deletedat filter used for MongoDB
+func isVisible(user *models.User, visibility Visibility) bool {
+	switch visibility {
+	case IncludeDeleted:
+		return true
+	case OnlyDeleted:
+		return user.IsDeleted()
+	default:
+		return !user.IsDeleted()
+	}
+}
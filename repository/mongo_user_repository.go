@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ UserRepository = (*MongoUserRepository)(nil)
+
+// emailIndexName is the name of the unique email index managed by EnsureIndexes
+const emailIndexName = "email_unique"
+
+// MongoUserRepository stores users in a MongoDB collection
+type MongoUserRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoUserRepository creates a repository backed by the given collection
+func NewMongoUserRepository(collection *mongo.Collection) *MongoUserRepository {
+	return &MongoUserRepository{collection: collection}
+}
+
+// EnsureIndexes creates the indexes the repository relies on, it's safe to call on every startup
+func (r *MongoUserRepository) EnsureIndexes(ctx context.Context) error {
+	// Unique, case-insensitive (strength 2 collation) index on email. Documents without an email are left out
+	// of the index so users created through the message queue without one don't conflict with each other.
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetName(emailIndexName).
+			SetUnique(true).
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The purger looks up users whose grace period has passed, only deleted users have a purge date
+	_, err = r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "purgeafter", Value: 1}},
+		Options: options.Index().SetName("purgeafter").SetSparse(true),
+	})
+	return err
+}
+
+func (r *MongoUserRepository) Create(ctx context.Context, user *models.User) error {
+	user.Version = 1
+	user.UpdatedAt = now()
+	// Insert the data into the database, result contains the newly generated Object ID for the new document
+	result, err := r.collection.InsertOne(ctx, user)
+	if err != nil {
+		return translateWriteError(err)
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *MongoUserRepository) FindByID(ctx context.Context, id primitive.ObjectID, visibility Visibility) (*models.User, error) {
+	return r.findOne(ctx, visible(bson.M{"_id": id}, visibility))
+}
+
+func (r *MongoUserRepository) FindByUserID(ctx context.Context, userID string, visibility Visibility) (*models.User, error) {
+	return r.findOne(ctx, visible(bson.M{"userid": userID}, visibility))
+}
+
+func (r *MongoUserRepository) Update(ctx context.Context, user *models.User, expectedVersion int64) (*models.User, error) {
+	// Convert the data to be updated into an unordered Bson document
+	update := bson.M{
+		"email":            user.Email,
+		"phone":            user.Phone,
+		"dateofbirth":      user.DateOfBirth,
+		"firstname":        user.FirstName,
+		"lastname":         user.LastName,
+		"creditcardnumber": user.CreditCardNumber,
+		"expirationdate":   user.ExpirationDate,
+		"cvc":              user.CVC,
+		"version":          expectedVersion + 1,
+		"updatedat":        now(),
+	}
+
+	// Only update the document when nobody else changed it since the caller read it.
+	// To return the updated document instead of original we have to add options.
+	filter := visible(bson.M{"_id": user.ID, "version": expectedVersion}, ExcludeDeleted)
+	result := r.collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": update}, options.FindOneAndUpdate().SetReturnDocument(options.After))
+
+	updated, err := decodeUser(result)
+	if errors.Is(err, ErrNotFound) {
+		// Nothing matched, find out whether the user is missing or has a different version
+		if _, findErr := r.FindByID(ctx, user.ID, ExcludeDeleted); findErr == nil {
+			return nil, ErrVersionConflict
+		}
+	}
+	return updated, translateWriteError(err)
+}
+
+func (r *MongoUserRepository) SoftDeleteByUserID(ctx context.Context, userID string, deletedAt, purgeAfter time.Time) (int64, error) {
+	filter := visible(bson.M{"userid": userID}, ExcludeDeleted)
+	update := bson.M{
+		"$set": bson.M{"deletedat": deletedAt, "purgeafter": purgeAfter, "updatedat": now()},
+		"$inc": bson.M{"version": 1},
+	}
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *MongoUserRepository) RestoreByUserID(ctx context.Context, userID string, at time.Time) (int64, error) {
+	filter := bson.M{"userid": userID, "deletedat": bson.M{"$exists": true}, "purgeafter": bson.M{"$gt": at}}
+	update := bson.M{
+		"$unset": bson.M{"deletedat": "", "purgeafter": ""},
+		"$set":   bson.M{"updatedat": now()},
+		"$inc":   bson.M{"version": 1},
+	}
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *MongoUserRepository) ListPurgeable(ctx context.Context, at time.Time, limit int) ([]string, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "purgeafter", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"userid": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"purgeafter": bson.M{"$lte": at}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return distinctUserIDs(users), nil
+}
+
+func (r *MongoUserRepository) PurgeByUserID(ctx context.Context, userID string, now time.Time) (int64, error) {
+	filter := bson.M{"userid": userID, "deletedat": bson.M{"$exists": true}, "purgeafter": bson.M{"$lte": now}}
+	result, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (r *MongoUserRepository) List(ctx context.Context, visibility Visibility, fn func(*models.User) error) error {
+	// collection.Find returns a cursor for our query
+	cursor, err := r.collection.Find(ctx, visible(bson.M{}, visibility))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	// cursor.Next() returns a boolean, if false there are no more items and loop will break
+	for cursor.Next(ctx) {
+		user := &models.User{}
+		if err := cursor.Decode(user); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r *MongoUserRepository) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	return decodeUser(r.collection.FindOne(ctx, filter))
+}
+
+// visible narrows the filter down to users that aren't pending deletion, unless deleted users are requested
+func visible(filter bson.M, visibility Visibility) bson.M {
+	switch visibility {
+	case ExcludeDeleted:
+		filter["deletedat"] = bson.M{"$exists": false}
+	case OnlyDeleted:
+		filter["deletedat"] = bson.M{"$exists": true}
+	}
+	return filter
+}
+
+// decodeUser decodes a single result and translates a missing document into ErrNotFound
+func decodeUser(result *mongo.SingleResult) (*models.User, error) {
+	user := &models.User{}
+	if err := result.Decode(user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// translateWriteError maps a violation of the unique email index onto ErrDuplicateEmail
+func translateWriteError(err error) error {
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), emailIndexName) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+// now returns the current time with the millisecond precision MongoDB stores
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
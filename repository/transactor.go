@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs a function inside a transaction, every store used with the context passed to fn takes part in it
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+var (
+	_ Transactor = (*MongoTransactor)(nil)
+	_ Transactor = (*MemoryTransactor)(nil)
+)
+
+// MongoTransactor runs functions in a MongoDB multi-document transaction
+type MongoTransactor struct {
+	client *mongo.Client
+}
+
+// NewMongoTransactor creates a transactor using sessions of the given client
+func NewMongoTransactor(client *mongo.Client) *MongoTransactor {
+	return &MongoTransactor{client: client}
+}
+
+func (t *MongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// The session context carries the transaction, so operations using it are committed or aborted together.
+	// WithTransaction retries fn on transient errors, so fn must be safe to run more than once.
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// MemoryTransactor serializes functions for the in-memory stores. It can't roll back, a failing fn
+// leaves the changes it already made in place.
+type MemoryTransactor struct {
+	mu sync.Mutex
+}
+
+// NewMemoryTransactor creates a transactor for use with the in-memory stores
+func NewMemoryTransactor() *MemoryTransactor {
+	return &MemoryTransactor{}
+}
+
+func (t *MemoryTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return fn(ctx)
+}
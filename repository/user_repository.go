@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned when no user matches the given identifier
+var ErrNotFound = errors.New("user not found")
+
+// ErrDuplicateEmail is returned when another user already uses the (normalized) email address
+var ErrDuplicateEmail = errors.New("email address is already in use")
+
+// ErrVersionConflict is returned when the stored version of a user doesn't match the expected version
+var ErrVersionConflict = errors.New("user was modified concurrently")
+
+// Visibility controls whether users pending deletion are returned by reads
+type Visibility int
+
+const (
+	// ExcludeDeleted hides users that have been (soft) deleted, it's what reads should use by default
+	ExcludeDeleted Visibility = iota
+	// IncludeDeleted also returns users that are pending deletion
+	IncludeDeleted
+	// OnlyDeleted returns nothing but users that are pending deletion
+	OnlyDeleted
+)
+
+// UserRepository abstracts the storage of users so handlers don't depend on MongoDB directly
+type UserRepository interface {
+	// Create stores a new user at version 1 and fills in the generated ID, it returns ErrDuplicateEmail when the email is taken
+	Create(ctx context.Context, user *models.User) error
+	// FindByID returns the user with the given document ID
+	FindByID(ctx context.Context, id primitive.ObjectID, visibility Visibility) (*models.User, error)
+	// FindByUserID returns the user belonging to the given (external) user id
+	FindByUserID(ctx context.Context, userID string, visibility Visibility) (*models.User, error)
+	// Update overwrites the editable fields of the user with the same ID and returns the updated user with its
+	// version incremented. It returns ErrVersionConflict when the stored version isn't expectedVersion and
+	// ErrDuplicateEmail when the new email is taken by another user. Users pending deletion can't be updated.
+	Update(ctx context.Context, user *models.User, expectedVersion int64) (*models.User, error)
+	// SoftDeleteByUserID marks all documents of the user id as deleted, to be purged after purgeAfter.
+	// It returns how many documents were marked, documents that were already deleted are left alone.
+	SoftDeleteByUserID(ctx context.Context, userID string, deletedAt, purgeAfter time.Time) (int64, error)
+	// RestoreByUserID undoes a soft delete of the user id as long as the grace period hasn't passed at now,
+	// it returns how many documents were restored
+	RestoreByUserID(ctx context.Context, userID string, now time.Time) (int64, error)
+	// ListPurgeable returns up to limit user ids whose grace period has passed at now
+	ListPurgeable(ctx context.Context, now time.Time, limit int) ([]string, error)
+	// PurgeByUserID removes the documents of the user id that are pending deletion and whose grace period has
+	// passed at now, and returns how many were removed. A user created again with the same id is left alone.
+	PurgeByUserID(ctx context.Context, userID string, now time.Time) (int64, error)
+	// List calls fn for every stored user, stopping at the first error
+	List(ctx context.Context, visibility Visibility, fn func(*models.User) error) error
+}
+
+// distinctUserIDs returns the external user ids of the users in order, without duplicates
+func distinctUserIDs(users []models.User) []string {
+	seen := make(map[string]bool, len(users))
+	var ids []string
+	for _, user := range users {
+		if user.UserID == "" || seen[user.UserID] {
+			continue
+		}
+		seen[user.UserID] = true
+		ids = append(ids, user.UserID)
+	}
+	return ids
+}
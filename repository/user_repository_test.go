@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The same cases run against every implementation, so the in-memory repository used by tests behaves like
+// the MongoDB one the service runs with.
+
+func TestMemoryUserRepository(t *testing.T) {
+	testUserRepository(t, func(t *testing.T) UserRepository {
+		return NewMemoryUserRepository()
+	})
+}
+
+// TestMongoUserRepository runs against the MongoDB given by MONGODB_TEST_URI, e.g. mongodb://localhost:27017,
+// and is skipped without it. Every case gets a database of its own that is dropped afterwards.
+func TestMongoUserRepository(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	testUserRepository(t, func(t *testing.T) UserRepository {
+		db := client.Database("userservice_test_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() { db.Drop(context.Background()) })
+
+		users := NewMongoUserRepository(db.Collection("users"))
+		if err := users.EnsureIndexes(context.Background()); err != nil {
+			t.Fatalf("Failed to create indexes: %v", err)
+		}
+		return users
+	})
+}
+
+func testUserRepository(t *testing.T, newRepository func(t *testing.T) UserRepository) {
+	ctx := context.Background()
+
+	t.Run("create and find", func(t *testing.T) {
+		users := newRepository(t)
+		user := &models.User{UserID: "user-1", Email: "jane@example.com", FirstName: "Jane"}
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if user.ID.IsZero() || user.Version != 1 {
+			t.Fatalf("Create should fill in the id and start at version 1, got id %s version %d", user.ID.Hex(), user.Version)
+		}
+
+		byID, err := users.FindByID(ctx, user.ID, ExcludeDeleted)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		if byID.FirstName != "Jane" || byID.UserID != "user-1" {
+			t.Errorf("FindByID returned %+v", byID)
+		}
+		byUserID, err := users.FindByUserID(ctx, "user-1", ExcludeDeleted)
+		if err != nil {
+			t.Fatalf("FindByUserID: %v", err)
+		}
+		if byUserID.ID != user.ID {
+			t.Errorf("FindByUserID returned %s, want %s", byUserID.ID.Hex(), user.ID.Hex())
+		}
+
+		if _, err := users.FindByID(ctx, primitive.NewObjectID(), ExcludeDeleted); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FindByID of a missing user returned %v, want ErrNotFound", err)
+		}
+		if _, err := users.FindByUserID(ctx, "missing", ExcludeDeleted); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FindByUserID of a missing user returned %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("duplicate email", func(t *testing.T) {
+		users := newRepository(t)
+		if err := users.Create(ctx, &models.User{UserID: "user-1", Email: "jane@example.com"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		err := users.Create(ctx, &models.User{UserID: "user-2", Email: "Jane@Example.com"})
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Create with an email differing in case returned %v, want ErrDuplicateEmail", err)
+		}
+
+		// Users without an email never conflict
+		for _, userID := range []string{"user-3", "user-4"} {
+			if err := users.Create(ctx, &models.User{UserID: userID}); err != nil {
+				t.Errorf("Create without email: %v", err)
+			}
+		}
+	})
+
+	t.Run("empty user id", func(t *testing.T) {
+		users := newRepository(t)
+		// Users created over gRPC don't have a user id, an empty id must never find or change them
+		if err := users.Create(ctx, &models.User{Email: "jane@example.com"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		now := time.Now().UTC()
+
+		if _, err := users.FindByUserID(ctx, "", IncludeDeleted); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FindByUserID with an empty id returned %v, want ErrNotFound", err)
+		}
+		if marked, err := users.SoftDeleteByUserID(ctx, "", now, now); err != nil || marked != 0 {
+			t.Errorf("SoftDeleteByUserID with an empty id marked %d, %v; want 0", marked, err)
+		}
+		if restored, err := users.RestoreByUserID(ctx, "", now); err != nil || restored != 0 {
+			t.Errorf("RestoreByUserID with an empty id restored %d, %v; want 0", restored, err)
+		}
+		if deleted, err := users.PurgeByUserID(ctx, "", now); err != nil || deleted != 0 {
+			t.Errorf("PurgeByUserID with an empty id removed %d, %v; want 0", deleted, err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		users := newRepository(t)
+		jane := &models.User{UserID: "user-1", Email: "jane@example.com", Phone: "111"}
+		john := &models.User{UserID: "user-2", Email: "john@example.com"}
+		for _, user := range []*models.User{jane, john} {
+			if err := users.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+
+		changed := *jane
+		changed.Phone = "222"
+		updated, err := users.Update(ctx, &changed, 1)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if updated.Phone != "222" || updated.Version != 2 || updated.UserID != "user-1" {
+			t.Errorf("Update returned %+v, want the new phone at version 2", updated)
+		}
+
+		if _, err := users.Update(ctx, &changed, 1); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("Update with a stale version returned %v, want ErrVersionConflict", err)
+		}
+		changed.Email = "john@example.com"
+		if _, err := users.Update(ctx, &changed, 2); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Update to a taken email returned %v, want ErrDuplicateEmail", err)
+		}
+		missing := &models.User{ID: primitive.NewObjectID()}
+		if _, err := users.Update(ctx, missing, 1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update of a missing user returned %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("soft delete and restore", func(t *testing.T) {
+		users := newRepository(t)
+		user := &models.User{UserID: "user-1", Email: "jane@example.com"}
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+		purgeAfter := deletedAt.Add(time.Hour)
+		if marked, err := users.SoftDeleteByUserID(ctx, "user-1", deletedAt, purgeAfter); err != nil || marked != 1 {
+			t.Fatalf("SoftDeleteByUserID marked %d, %v", marked, err)
+		}
+		if marked, err := users.SoftDeleteByUserID(ctx, "user-1", deletedAt, purgeAfter.Add(time.Hour)); err != nil || marked != 0 {
+			t.Errorf("Deleting again marked %d, %v; want 0", marked, err)
+		}
+
+		if _, err := users.FindByUserID(ctx, "user-1", ExcludeDeleted); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FindByUserID of a deleted user returned %v, want ErrNotFound", err)
+		}
+		deleted, err := users.FindByUserID(ctx, "user-1", OnlyDeleted)
+		if err != nil {
+			t.Fatalf("FindByUserID of deleted users: %v", err)
+		}
+		if !deleted.PurgeAfter.Equal(purgeAfter) || deleted.Version != 2 {
+			t.Errorf("Deleted user is %+v, want purge after %s at version 2", deleted, purgeAfter)
+		}
+		if _, err := users.Update(ctx, deleted, deleted.Version); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update of a deleted user returned %v, want ErrNotFound", err)
+		}
+
+		if restored, err := users.RestoreByUserID(ctx, "user-1", purgeAfter.Add(time.Second)); err != nil || restored != 0 {
+			t.Errorf("Restoring after the grace period restored %d, %v; want 0", restored, err)
+		}
+		if restored, err := users.RestoreByUserID(ctx, "user-1", deletedAt); err != nil || restored != 1 {
+			t.Fatalf("RestoreByUserID restored %d, %v", restored, err)
+		}
+		restored, err := users.FindByUserID(ctx, "user-1", ExcludeDeleted)
+		if err != nil {
+			t.Fatalf("FindByUserID after restoring: %v", err)
+		}
+		if restored.IsDeleted() || !restored.PurgeAfter.IsZero() || restored.Version != 3 {
+			t.Errorf("Restored user is %+v, want it undeleted at version 3", restored)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		users := newRepository(t)
+		for _, userID := range []string{"user-1", "user-2", "user-3"} {
+			if err := users.Create(ctx, &models.User{UserID: userID}); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		// user-2 expired before user-1, user-3 is still within its grace period
+		for userID, purgeAfter := range map[string]time.Time{
+			"user-1": now.Add(-time.Minute),
+			"user-2": now.Add(-time.Hour),
+			"user-3": now.Add(time.Hour),
+		} {
+			if _, err := users.SoftDeleteByUserID(ctx, userID, now.Add(-2*time.Hour), purgeAfter); err != nil {
+				t.Fatalf("SoftDeleteByUserID: %v", err)
+			}
+		}
+
+		purgeable, err := users.ListPurgeable(ctx, now, 10)
+		if err != nil {
+			t.Fatalf("ListPurgeable: %v", err)
+		}
+		if len(purgeable) != 2 || purgeable[0] != "user-2" || purgeable[1] != "user-1" {
+			t.Errorf("ListPurgeable returned %v, want [user-2 user-1]", purgeable)
+		}
+		if limited, err := users.ListPurgeable(ctx, now, 1); err != nil || len(limited) != 1 || limited[0] != "user-2" {
+			t.Errorf("ListPurgeable with a limit of 1 returned %v, %v", limited, err)
+		}
+
+		if deleted, err := users.PurgeByUserID(ctx, "user-2", now); err != nil || deleted != 1 {
+			t.Fatalf("PurgeByUserID removed %d, %v", deleted, err)
+		}
+		if _, err := users.FindByUserID(ctx, "user-2", IncludeDeleted); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FindByUserID of a purged user returned %v, want ErrNotFound", err)
+		}
+		if deleted, err := users.PurgeByUserID(ctx, "user-2", now); err != nil || deleted != 0 {
+			t.Errorf("Purging again removed %d, %v; want 0", deleted, err)
+		}
+		if deleted, err := users.PurgeByUserID(ctx, "user-3", now); err != nil || deleted != 0 {
+			t.Errorf("Purging a user within the grace period removed %d, %v; want 0", deleted, err)
+		}
+
+		// A user created again with the id of a deleted one survives the purge of the old documents
+		if err := users.Create(ctx, &models.User{UserID: "user-1", FirstName: "Again"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if deleted, err := users.PurgeByUserID(ctx, "user-1", now); err != nil || deleted != 1 {
+			t.Fatalf("PurgeByUserID removed %d, %v; want only the deleted document", deleted, err)
+		}
+		if again, err := users.FindByUserID(ctx, "user-1", ExcludeDeleted); err != nil || again.FirstName != "Again" {
+			t.Errorf("FindByUserID of the new user returned %+v, %v", again, err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		users := newRepository(t)
+		for _, userID := range []string{"user-1", "user-2"} {
+			if err := users.Create(ctx, &models.User{UserID: userID}); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+		now := time.Now().UTC()
+		if _, err := users.SoftDeleteByUserID(ctx, "user-2", now, now.Add(time.Hour)); err != nil {
+			t.Fatalf("SoftDeleteByUserID: %v", err)
+		}
+
+		count := func(visibility Visibility) int {
+			var n int
+			if err := users.List(ctx, visibility, func(*models.User) error { n++; return nil }); err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			return n
+		}
+		if n := count(ExcludeDeleted); n != 1 {
+			t.Errorf("List without deleted users returned %d user(s), want 1", n)
+		}
+		if n := count(IncludeDeleted); n != 2 {
+			t.Errorf("List including deleted users returned %d user(s), want 2", n)
+		}
+		if n := count(OnlyDeleted); n != 1 {
+			t.Errorf("List of deleted users returned %d user(s), want 1", n)
+		}
+
+		stop := errors.New("stop")
+		if err := users.List(ctx, IncludeDeleted, func(*models.User) error { return stop }); !errors.Is(err, stop) {
+			t.Errorf("List returned %v, want the error of the callback", err)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+)
+
+// AllFields is the field path that selects every editable field
+const AllFields = "*"
+
+// FieldError is returned when an update names a field that doesn't exist or can't be changed
+type FieldError struct {
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return e.Reason
+}
+
+// editableField copies a field from the changes onto the stored user and tells whether the changes set it
+type editableField struct {
+	set   func(dst, src *models.User)
+	isSet func(u *models.User) bool
+}
+
+// editableFields maps every editable field path onto its field
+var editableFields = map[string]editableField{
+	"email": {
+		set:   func(dst, src *models.User) { dst.Email = models.NormalizeEmail(src.Email) },
+		isSet: func(u *models.User) bool { return u.Email != "" },
+	},
+	"phone": {
+		set:   func(dst, src *models.User) { dst.Phone = src.Phone },
+		isSet: func(u *models.User) bool { return u.Phone != "" },
+	},
+	"date_of_birth": {
+		set:   func(dst, src *models.User) { dst.DateOfBirth = src.DateOfBirth },
+		isSet: func(u *models.User) bool { return u.DateOfBirth != "" },
+	},
+	"first_name": {
+		set:   func(dst, src *models.User) { dst.FirstName = src.FirstName },
+		isSet: func(u *models.User) bool { return u.FirstName != "" },
+	},
+	"last_name": {
+		set:   func(dst, src *models.User) { dst.LastName = src.LastName },
+		isSet: func(u *models.User) bool { return u.LastName != "" },
+	},
+	"credit_card_number": {
+		set:   func(dst, src *models.User) { dst.CreditCardNumber = src.CreditCardNumber },
+		isSet: func(u *models.User) bool { return u.CreditCardNumber != 0 },
+	},
+	"expiration_date": {
+		set:   func(dst, src *models.User) { dst.ExpirationDate = src.ExpirationDate },
+		isSet: func(u *models.User) bool { return u.ExpirationDate != "" },
+	},
+	"cvc": {
+		set:   func(dst, src *models.User) { dst.CVC = src.CVC },
+		isSet: func(u *models.User) bool { return u.CVC != 0 },
+	},
+}
+
+// ResolveFields returns the sorted fields an update should modify. Without paths only the fields set in the
+// changes are used, so a client changing one field doesn't wipe the others.
+func ResolveFields(paths []string, changes *models.User) ([]string, error) {
+	if len(paths) == 0 {
+		return populatedFields(changes), nil
+	}
+
+	seen := map[string]bool{}
+	var fields []string
+	for _, path := range paths {
+		if path == AllFields {
+			if len(paths) > 1 {
+				return nil, &FieldError{Reason: fmt.Sprintf("%q can't be combined with other paths", AllFields)}
+			}
+			return allFields(), nil
+		}
+		if _, ok := editableFields[path]; !ok {
+			return nil, &FieldError{Reason: fmt.Sprintf("unknown or read-only field %q", path)}
+		}
+		if !seen[path] {
+			seen[path] = true
+			fields = append(fields, path)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// applyFields copies the given fields from the changes onto the stored user
+func applyFields(dst, src *models.User, fields []string) {
+	for _, field := range fields {
+		editableFields[field].set(dst, src)
+	}
+}
+
+// populatedFields lists the editable fields that hold a non-zero value in the changes
+func populatedFields(changes *models.User) []string {
+	var fields []string
+	for _, field := range allFields() {
+		if editableFields[field].isSet(changes) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func allFields() []string {
+	fields := make([]string, 0, len(editableFields))
+	for field := range editableFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/audit"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/events"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/models"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/outbox"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrGracePeriodPassed is returned when restoring a user that is about to be purged
+var ErrGracePeriodPassed = errors.New("grace period has passed")
+
+// Origin describes where a change comes from, it's recorded in the audit trail
+type Origin struct {
+	// Actor is who asked for the change, Action the RPC or message action and Source the interface it came through
+	Actor  string
+	Action string
+	Source string
+}
+
+// Ref identifies a user by its document ID, or by the id used by the other services when ID is zero
+type Ref struct {
+	ID     primitive.ObjectID
+	UserID string
+}
+
+// String returns whichever id the reference holds
+func (r Ref) String() string {
+	if !r.ID.IsZero() {
+		return r.ID.Hex()
+	}
+	return r.UserID
+}
+
+// UserService changes users the same way for the gRPC handlers and the user queue. Every change is stored
+// together with its audit entry and user event in one transaction. Errors of the repository, such as
+// repository.ErrNotFound and repository.ErrDuplicateEmail, are returned as is.
+type UserService struct {
+	users  repository.UserRepository
+	audit  audit.Store
+	outbox outbox.Store
+	tx     repository.Transactor
+	// deletionGracePeriod is how long a deleted user can be restored before it's purged
+	deletionGracePeriod time.Duration
+	// eventsExchange is the exchange the user events are published to
+	eventsExchange string
+}
+
+// NewUserService creates the service on top of the given stores
+func NewUserService(users repository.UserRepository, auditStore audit.Store, outboxStore outbox.Store, tx repository.Transactor, deletionGracePeriod time.Duration, eventsExchange string) *UserService {
+	return &UserService{
+		users:               users,
+		audit:               auditStore,
+		outbox:              outboxStore,
+		tx:                  tx,
+		deletionGracePeriod: deletionGracePeriod,
+		eventsExchange:      eventsExchange,
+	}
+}
+
+// Get returns the user the reference points to
+func (s *UserService) Get(ctx context.Context, ref Ref, visibility repository.Visibility) (*models.User, error) {
+	if !ref.ID.IsZero() {
+		return s.users.FindByID(ctx, ref.ID, visibility)
+	}
+	return s.users.FindByUserID(ctx, ref.UserID, visibility)
+}
+
+// Create stores a new user, the repository fills in the newly generated Object ID and version
+func (s *UserService) Create(ctx context.Context, origin Origin, user *models.User) error {
+	user.Email = models.NormalizeEmail(user.Email)
+	return s.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Create(ctx, user); err != nil {
+			return err
+		}
+		return s.recordChange(ctx, origin, events.TypeCreated, nil, user)
+	})
+}
+
+// Update copies the given fields from changes onto the stored user and returns the updated user. Without
+// fields, the fields set in changes are used. The update fails with repository.ErrVersionConflict when the
+// stored version isn't expectedVersion; an expectedVersion of 0 updates the version that was just read.
+// Users pending deletion can't be updated.
+func (s *UserService) Update(ctx context.Context, origin Origin, ref Ref, expectedVersion int64, changes *models.User, fields []string) (*models.User, error) {
+	// Work out which fields to change before touching the database
+	fields, err := ResolveFields(fields, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the stored user and merge the selected fields into it
+	update, err := s.Get(ctx, ref, repository.ExcludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	before := *update
+	applyFields(update, changes, fields)
+	if expectedVersion == 0 {
+		expectedVersion = before.Version
+	}
+
+	// The repository returns the updated document instead of the original
+	var updated *models.User
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if updated, err = s.users.Update(ctx, update, expectedVersion); err != nil {
+			return err
+		}
+		return s.recordChange(ctx, origin, events.TypeUpdated, &before, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Delete marks the users with the given id as deleted and returns when they'll be purged. Deleting a user that
+// is already pending deletion succeeds without extending the grace period.
+func (s *UserService) Delete(ctx context.Context, origin Origin, userID string) (time.Time, error) {
+	deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+	purgeAfter := deletedAt.Add(s.deletionGracePeriod)
+	var marked int64
+	err := s.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if marked, err = s.users.SoftDeleteByUserID(ctx, userID, deletedAt, purgeAfter); err != nil || marked == 0 {
+			return err
+		}
+		// Record the deletion along with the user.deleted event
+		before := &models.User{UserID: userID}
+		after := &models.User{UserID: userID, DeletedAt: deletedAt, PurgeAfter: purgeAfter}
+		return s.recordChange(ctx, origin, events.TypeDeleted, before, after)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if marked == 0 {
+		existing, err := s.users.FindByUserID(ctx, userID, repository.IncludeDeleted)
+		if err != nil {
+			return time.Time{}, err
+		}
+		purgeAfter = existing.PurgeAfter
+	}
+	return purgeAfter, nil
+}
+
+// Restore undoes the deletion of a user. It returns repository.ErrNotFound when no user with the id is pending
+// deletion and ErrGracePeriodPassed when the user is about to be purged.
+func (s *UserService) Restore(ctx context.Context, origin Origin, userID string) error {
+	// Remember the pending deletion for the audit trail
+	before, err := s.users.FindByUserID(ctx, userID, repository.IncludeDeleted)
+	if err != nil {
+		return err
+	}
+	after := *before
+	after.DeletedAt, after.PurgeAfter = time.Time{}, time.Time{}
+
+	// Undo the soft delete, this only works while the grace period hasn't passed
+	var restored int64
+	err = s.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if restored, err = s.users.RestoreByUserID(ctx, userID, time.Now().UTC()); err != nil || restored == 0 {
+			return err
+		}
+		// Record the restore along with the user.restored event
+		return s.recordChange(ctx, origin, events.TypeRestored, before, &after)
+	})
+	if err != nil {
+		return err
+	}
+
+	if restored == 0 {
+		// Tell apart a user that isn't deleted from one that is about to be purged
+		if before.IsDeleted() {
+			return ErrGracePeriodPassed
+		}
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// recordChange appends the audit entry and queues the user event for a change. It has to be called inside the
+// transaction making the change, so all three are committed together.
+func (s *UserService) recordChange(ctx context.Context, origin Origin, eventType string, before, after *models.User) error {
+	entry := audit.NewEntry(origin.Actor, origin.Action, origin.Source, before, after)
+	if err := s.audit.Append(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	message, err := events.NewUserEventMessage(s.eventsExchange, eventType, before, after)
+	if err != nil {
+		return fmt.Errorf("failed to build %s event: %w", eventType, err)
+	}
+	if err := s.outbox.Add(ctx, message); err != nil {
+		return fmt.Errorf("failed to queue %s event: %w", eventType, err)
+	}
+	return nil
+}
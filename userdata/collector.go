@@ -0,0 +1,106 @@
+package userdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/messaging"
+	"github.com/Portfolio-Advanced-software/BingeBuster-UserService/repository"
+)
+
+// ProfileSource is the section holding the user document stored by this service
+const ProfileSource = "user_profile"
+
+// Section is the data one source holds about a user
+type Section struct {
+	Source string
+	Status messaging.ReplyStatus
+	// CollectedAt is when the data arrived, or when the source failed or timed out
+	CollectedAt time.Time
+	// Payload holds the data when it's valid JSON, otherwise the data is kept as is in Raw
+	Payload json.RawMessage
+	Raw     []byte
+	// Err explains why the source failed or timed out
+	Err string
+}
+
+// Collector gathers everything that is known about a user, from this service and the downstream services
+type Collector struct {
+	users  repository.UserRepository
+	broker messaging.Broker
+	// sources are asked for their data of a user, their sections follow the user profile in this order
+	sources []messaging.Downstream
+	// timeout is how long the downstream services get to reply
+	timeout time.Duration
+}
+
+// NewCollector creates a collector that asks the downstream services through the broker and waits at most
+// timeout for their replies
+func NewCollector(users repository.UserRepository, broker messaging.Broker, sources []messaging.Downstream, timeout time.Duration) *Collector {
+	return &Collector{users: users, broker: broker, sources: sources, timeout: timeout}
+}
+
+// Collect returns one section per source, starting with the user profile. It returns repository.ErrNotFound
+// when the user doesn't exist, a downstream service that fails or doesn't reply in time only fails its own section.
+func (c *Collector) Collect(ctx context.Context, userID string) ([]Section, error) {
+	// Users pending deletion still have their data, so they are included
+	user, err := c.users.FindByUserID(ctx, userID, repository.IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the user document to JSON, the same shape other services get it in
+	profile, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal record to JSON: %v", err)
+	}
+	sections := []Section{answered(ProfileSource, profile, time.Now().UTC())}
+
+	// Prepare the data of the request
+	data := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	// Ask every service for its data and wait for the replies, but never longer than the configured
+	// timeout or the deadline of the caller, whichever comes first
+	requestCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	queues := make([]messaging.Queue, 0, len(c.sources))
+	for _, source := range c.sources {
+		queues = append(queues, source.Queue)
+	}
+	replies, err := c.broker.RequestAll(requestCtx, messaging.TypeGetAllRecords, data, queues...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request user data: %v", err)
+	}
+
+	// Every service gets its own section, also when it failed or didn't answer in time
+	for i, reply := range replies {
+		service := c.sources[i].Service
+		if reply.Status != messaging.ReplyAnswered {
+			sections = append(sections, Section{
+				Source:      service,
+				Status:      reply.Status,
+				CollectedAt: reply.CompletedAt,
+				Err:         reply.Err.Error(),
+			})
+			continue
+		}
+		sections = append(sections, answered(service, reply.Body, reply.CompletedAt))
+	}
+	return sections, nil
+}
+
+// answered creates the section of a source that replied. Data that isn't valid JSON is kept as is so nothing gets lost.
+func answered(source string, data []byte, collectedAt time.Time) Section {
+	section := Section{Source: source, Status: messaging.ReplyAnswered, CollectedAt: collectedAt}
+	if json.Valid(data) {
+		section.Payload = data
+	} else {
+		section.Raw = data
+	}
+	return section
+}